@@ -0,0 +1,194 @@
+package nnsearch
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// AnalogyMethod is a way of scoring the answers of an analogy.
+type AnalogyMethod int
+
+const (
+	// 3CosAdd finds the words closest to the sum of the normalized positive
+	// vectors minus the normalized negative ones.
+	CosAdd AnalogyMethod = iota
+
+	// 3CosMul ranks the candidates found by CosAdd by the product of their
+	// similarities to the positive words divided by the product of their
+	// similarities to the negative words, which keeps one large similarity
+	// from dominating.
+	CosMul
+)
+
+type AnalogyOptions struct {
+	Method AnalogyMethod
+
+	// Number of candidates found by the search to be ranked by CosMul.
+	// Defaults to 10 times the number of results, and at least 50.
+	Candidates int
+
+	// Options of the search. A filter is applied in addition to excluding the
+	// words of the query.
+	Search *SearchOptions
+}
+
+func getAnalogyOptions(in *AnalogyOptions, k int) *AnalogyOptions {
+	var out AnalogyOptions
+	if in != nil {
+		out = *in
+	}
+
+	if out.Candidates == 0 {
+		out.Candidates = 10 * k
+		if out.Candidates < 50 {
+			out.Candidates = 50
+		}
+	}
+	if out.Candidates < k {
+		out.Candidates = k
+	}
+	return &out
+}
+
+func normalized(vec []float32) []float32 {
+	var length float64
+	for _, x := range vec {
+		length += float64(x) * float64(x)
+	}
+
+	out := make([]float32, len(vec))
+	if length == 0 {
+		return out
+	}
+	length = math.Sqrt(length)
+	for i, x := range vec {
+		out[i] = float32(float64(x) / length)
+	}
+	return out
+}
+
+func cosineSimilarity(vec1, vec2 []float32) float64 {
+	var len1, len2, dot float64
+	for i := range vec1 {
+		len1 += float64(vec1[i]) * float64(vec1[i])
+		len2 += float64(vec2[i]) * float64(vec2[i])
+		dot += float64(vec1[i]) * float64(vec2[i])
+	}
+
+	if len1 == 0 || len2 == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(len1*len2)
+}
+
+func (wv *WordVecs) vectors(words []string) ([][]float32, error) {
+	vecs := make([][]float32, len(words))
+	for i, word := range words {
+		vecs[i] = wv.Get(word)
+		if vecs[i] == nil {
+			return nil, fmt.Errorf("unknown word %q", word)
+		}
+	}
+	return vecs, nil
+}
+
+// Combine returns the sum of the normalized vectors of the positive words
+// minus those of the negative words, the query of "king - man + woman". With
+// only positive words, this is the centroid used to find words related to all
+// of them.
+func (wv *WordVecs) Combine(positive, negative []string) (*WordVector, error) {
+	if len(positive)+len(negative) == 0 {
+		return nil, fmt.Errorf("no words given")
+	}
+
+	pos, err := wv.vectors(positive)
+	if err != nil {
+		return nil, err
+	}
+	neg, err := wv.vectors(negative)
+	if err != nil {
+		return nil, err
+	}
+
+	total := make([]float32, wv.d)
+	for _, vec := range pos {
+		for i, x := range normalized(vec) {
+			total[i] += x
+		}
+	}
+	for _, vec := range neg {
+		for i, x := range normalized(vec) {
+			total[i] -= x
+		}
+	}
+
+	query := strings.Join(positive, " + ")
+	if len(negative) > 0 {
+		query += " - " + strings.Join(negative, " - ")
+	}
+	return &WordVector{Word: query, Vector: total}, nil
+}
+
+// Analogy finds the k words that best complete an analogy, searching an index
+// built over the vectors. The words of the query are never returned. With
+// CosAdd, the distances are those from the combined query vector. With CosMul,
+// the distances are the negated scores, so they still sort the best first.
+func (wv *WordVecs) Analogy(index SpaceIndex, positive, negative []string, k int, options *AnalogyOptions) ([]PointDistance, error) {
+	opt := getAnalogyOptions(options, k)
+	query, err := wv.Combine(positive, negative)
+	if err != nil {
+		return nil, err
+	}
+
+	exclude := make(map[string]bool)
+	for _, word := range positive {
+		exclude[word] = true
+	}
+	for _, word := range negative {
+		exclude[word] = true
+	}
+
+	var search SearchOptions
+	if opt.Search != nil {
+		search = *opt.Search
+	}
+	filter := search.Filter
+	search.Filter = func(pt Point) bool {
+		if word, ok := pt.(*WordVector); ok && exclude[word.Word] {
+			return false
+		}
+		return filter == nil || filter(pt)
+	}
+
+	if opt.Method == CosAdd {
+		return index.NearestNeighbours(query, k, &search), nil
+	}
+
+	candidates := index.NearestNeighbours(query, opt.Candidates, &search)
+	pos, _ := wv.vectors(positive)
+	neg, _ := wv.vectors(negative)
+
+	// similarities are shifted to [0, 1] so the products are positive
+	const epsilon = 0.001
+	for i := range candidates {
+		vec := VectorOf(candidates[i].Point)
+		num, den := 1.0, 1.0
+		for _, p := range pos {
+			num *= (cosineSimilarity(vec, p) + 1) / 2
+		}
+		for _, n := range neg {
+			den *= (cosineSimilarity(vec, n) + 1) / 2
+		}
+		candidates[i].Distance = -num / (den + epsilon)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Distance < candidates[j].Distance
+	})
+	if len(candidates) > k {
+		candidates = candidates[:k]
+	}
+	return candidates, nil
+}
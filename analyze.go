@@ -0,0 +1,322 @@
+package nnsearch
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// Options for analyzing a graph. All options are optional.
+type AnalyzeOptions struct {
+	// Number of random queries used to measure recall. Defaults to 100.
+	Queries int
+
+	// Number of neighbours requested by each query. Defaults to 10.
+	K int
+
+	// Number of random nodes from which reachability is measured. Defaults
+	// to 10.
+	EntryPoints int
+
+	// Number of nodes with the highest in-degree that are reported as hubs.
+	// Defaults to 10.
+	Hubs int
+}
+
+func getAnalyzeOptions(in *AnalyzeOptions) *AnalyzeOptions {
+	var out AnalyzeOptions
+	if in != nil {
+		out = *in
+	}
+
+	if out.Queries <= 0 {
+		out.Queries = 100
+	}
+
+	if out.K <= 0 {
+		out.K = 10
+	}
+
+	if out.EntryPoints <= 0 {
+		out.EntryPoints = 10
+	}
+
+	if out.Hubs <= 0 {
+		out.Hubs = 10
+	}
+
+	return &out
+}
+
+// DegreeStats summarizes the distribution of the degrees of the nodes.
+type DegreeStats struct {
+	Min    int     `json:"min"`
+	Median int     `json:"median"`
+	P99    int     `json:"p99"`
+	Max    int     `json:"max"`
+	Mean   float64 `json:"mean"`
+}
+
+// Hub is a node that many other nodes link to.
+type Hub struct {
+	Node     int `json:"node"`
+	InDegree int `json:"in_degree"`
+}
+
+// GraphReport describes the quality of a graph.
+type GraphReport struct {
+	Nodes     int         `json:"nodes"`
+	Edges     int         `json:"edges"`
+	OutDegree DegreeStats `json:"out_degree"`
+	InDegree  DegreeStats `json:"in_degree"`
+
+	WeakComponents         int `json:"weak_components"`
+	StrongComponents       int `json:"strong_components"`
+	LargestStrongComponent int `json:"largest_strong_component"`
+
+	// Nodes that cannot be reached by following edges from any of the random
+	// entry points.
+	Unreachable int `json:"unreachable"`
+
+	Hubs []Hub `json:"hubs"`
+
+	// The mean length of the edges, and its ratio to the median distance
+	// between random points of the space.
+	MeanEdgeLength     float64 `json:"mean_edge_length"`
+	MedianDistance     float64 `json:"median_distance"`
+	RelativeEdgeLength float64 `json:"relative_edge_length"`
+
+	// The fraction of the true k nearest neighbours of random nodes that
+	// NearestNeighbours returns.
+	Recall float64 `json:"recall"`
+}
+
+// WriteJSON writes the report as indented JSON.
+func (r *GraphReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// Analyze measures the structure of a graph or frozen graph, and the recall of
+// searches on it compared with a brute force search.
+func Analyze(g IGraph, options *AnalyzeOptions) *GraphReport {
+	opt := getAnalyzeOptions(options)
+	n := g.GetNodeCount()
+	report := &GraphReport{Nodes: n}
+	if n == 0 {
+		return report
+	}
+
+	log.Printf("Reading edges of %d nodes", n)
+	adj := make([][]int, n)
+	lengths := make([]float64, n)
+	ForkLoop(n, func(u int) {
+		edges := g.GetNeighbours(u)
+		adj[u] = make([]int, len(edges))
+		for i, e := range edges {
+			adj[u][i] = e.index
+			lengths[u] += e.distance
+		}
+	})
+
+	outDegree := make([]int, n)
+	inDegree := make([]int, n)
+	totalLength := 0.0
+	for u := range adj {
+		outDegree[u] = len(adj[u])
+		report.Edges += len(adj[u])
+		totalLength += lengths[u]
+		for _, v := range adj[u] {
+			inDegree[v]++
+		}
+	}
+
+	report.OutDegree = degreeStats(outDegree)
+	report.InDegree = degreeStats(inDegree)
+
+	sets := newDisjointSets(n)
+	for u := range adj {
+		for _, v := range adj[u] {
+			sets.union(u, v)
+		}
+	}
+	for u := 0; u < n; u++ {
+		if sets.find(u) == u {
+			report.WeakComponents++
+		}
+	}
+
+	report.StrongComponents, report.LargestStrongComponent = strongComponents(adj)
+	report.Unreachable = n - reachable(adj, randomSample(n, minInt(n, opt.EntryPoints)))
+
+	hubs := Sequence(n)
+	sort.Slice(hubs, func(a, b int) bool {
+		return inDegree[hubs[a]] > inDegree[hubs[b]]
+	})
+	for _, u := range hubs[:minInt(n, opt.Hubs)] {
+		report.Hubs = append(report.Hubs, Hub{u, inDegree[u]})
+	}
+
+	if report.Edges > 0 {
+		report.MeanEdgeLength = totalLength / float64(report.Edges)
+	}
+	if n > 1 {
+		report.MedianDistance = ComputeMedianDistance(g, 10000)
+	}
+	if report.MedianDistance > 0 {
+		report.RelativeEdgeLength = report.MeanEdgeLength / report.MedianDistance
+	}
+
+	log.Printf("Measuring recall over %d queries", opt.Queries)
+	bf := NewBruteForceIndex(g)
+	found, total := 0, 0
+	for i := 0; i < opt.Queries; i++ {
+		target := g.At(rand.Intn(n))
+		truth := make(map[int]bool)
+		for _, r := range bf.NearestNeighbours(target, opt.K, nil) {
+			truth[r.Index] = true
+		}
+		for _, r := range NearestNeighbours(g, target, opt.K, nil) {
+			if truth[r.Index] {
+				found++
+			}
+		}
+		total += len(truth)
+	}
+	if total > 0 {
+		report.Recall = float64(found) / float64(total)
+	}
+
+	return report
+}
+
+func degreeStats(degrees []int) DegreeStats {
+	sorted := append([]int(nil), degrees...)
+	sort.Ints(sorted)
+
+	sum := 0
+	for _, d := range sorted {
+		sum += d
+	}
+
+	n := len(sorted)
+	return DegreeStats{
+		Min:    sorted[0],
+		Median: sorted[n/2],
+		P99:    sorted[int(math.Min(float64(n-1), float64(n)*0.99))],
+		Max:    sorted[n-1],
+		Mean:   float64(sum) / float64(n),
+	}
+}
+
+// reachable returns the number of nodes reachable from the given nodes.
+func reachable(adj [][]int, from []int) int {
+	seen := make([]bool, len(adj))
+	var stack []int
+	count := 0
+	for _, u := range from {
+		if !seen[u] {
+			seen[u] = true
+			stack = append(stack, u)
+			count++
+		}
+	}
+
+	for len(stack) > 0 {
+		u := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, v := range adj[u] {
+			if !seen[v] {
+				seen[v] = true
+				stack = append(stack, v)
+				count++
+			}
+		}
+	}
+
+	return count
+}
+
+// strongComponents returns the number of strongly connected components of the
+// graph and the size of the largest, using Tarjan's algorithm without
+// recursion.
+func strongComponents(adj [][]int) (int, int) {
+	n := len(adj)
+	index := make([]int, n)
+	low := make([]int, n)
+	onStack := make([]bool, n)
+	for u := range index {
+		index[u] = -1
+	}
+
+	type frame struct {
+		u, next int
+	}
+
+	var stack []int
+	var calls []frame
+	next := 0
+	count, largest := 0, 0
+
+	for root := 0; root < n; root++ {
+		if index[root] >= 0 {
+			continue
+		}
+
+		calls = append(calls, frame{root, 0})
+		index[root], low[root] = next, next
+		next++
+		stack = append(stack, root)
+		onStack[root] = true
+
+		for len(calls) > 0 {
+			f := &calls[len(calls)-1]
+			u := f.u
+			if f.next < len(adj[u]) {
+				v := adj[u][f.next]
+				f.next++
+				if index[v] < 0 {
+					index[v], low[v] = next, next
+					next++
+					stack = append(stack, v)
+					onStack[v] = true
+					calls = append(calls, frame{v, 0})
+				} else if onStack[v] && index[v] < low[u] {
+					low[u] = index[v]
+				}
+				continue
+			}
+
+			calls = calls[:len(calls)-1]
+			if len(calls) > 0 {
+				parent := calls[len(calls)-1].u
+				if low[u] < low[parent] {
+					low[parent] = low[u]
+				}
+			}
+
+			if low[u] == index[u] {
+				size := 0
+				for {
+					v := stack[len(stack)-1]
+					stack = stack[:len(stack)-1]
+					onStack[v] = false
+					size++
+					if v == u {
+						break
+					}
+				}
+				count++
+				if size > largest {
+					largest = size
+				}
+			}
+		}
+	}
+
+	return count, largest
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/smhanov/nnsearch"
+	"github.com/smhanov/nnsearch/eval"
+)
+
+func evaluate(args []string) error {
+	var in inputFlags
+	fs := flag.NewFlagSet("eval", flag.ExitOnError)
+	in.register(fs)
+	index := fs.String("index", "graph.dat", "graph saved by build")
+	k := fs.Int("k", 10, "number of neighbours requested by each query")
+	count := fs.Int("queries", 100, "number of queries sampled from the input")
+	seed := fs.Int64("seed", 1, "random seed used to sample the queries")
+	queryFile := fs.String("query-file", "", "file of query vectors, in the format of the input, instead of sampled queries")
+	truthFile := fs.String("truth", "", "ivecs file of true neighbours, or a file to cache the ground truth in")
+	epsilons := fs.String("epsilon", "", "comma separated values of the epsilon search option")
+	budgets := fs.String("budget", "", "comma separated values of the budget search option")
+	output := fs.String("output", "csv", "format of the results: csv or json")
+	fs.Parse(args)
+
+	space, err := in.open()
+	if err != nil {
+		return err
+	}
+
+	g, err := loadGraph(*index, space)
+	if err != nil {
+		return err
+	}
+
+	var queries []nnsearch.Point
+	if *queryFile != "" {
+		qin := in
+		qin.input = *queryFile
+		qspace, err := qin.open()
+		if err != nil {
+			return err
+		}
+		queries = eval.Points(qspace)
+	} else {
+		queries = eval.SampleQueriesWithSeed(space, *count, *seed)
+	}
+
+	var truth *eval.GroundTruth
+	switch {
+	case strings.ToLower(filepath.Ext(*truthFile)) == ".ivecs":
+		truth, err = eval.ReadIvecsGroundTruth(*truthFile)
+	case *truthFile != "":
+		truth, err = eval.CachedGroundTruth(*truthFile, space, queries, *k)
+	default:
+		truth = eval.ComputeGroundTruth(space, queries, *k)
+	}
+	if err != nil {
+		return err
+	}
+
+	eps, err := parseFloats(*epsilons)
+	if err != nil {
+		return err
+	}
+
+	budget, err := parseInts(*budgets)
+	if err != nil {
+		return err
+	}
+
+	results, err := eval.Run(g, queries, truth, *k, eval.Grid(eps, budget))
+	if err != nil {
+		return err
+	}
+
+	switch *output {
+	case "csv":
+		return eval.WriteCSV(os.Stdout, results)
+	case "json":
+		return eval.WriteJSON(os.Stdout, results)
+	}
+	return fmt.Errorf("unknown output format %q", *output)
+}
+
+func parseFloats(list string) ([]float64, error) {
+	var result []float64
+	for _, s := range strings.Split(list, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		x, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, x)
+	}
+	return result, nil
+}
+
+func parseInts(list string) ([]int, error) {
+	var result []int
+	for _, s := range strings.Split(list, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		x, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, x)
+	}
+	return result, nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/smhanov/nnsearch"
+)
+
+func inspect(args []string) error {
+	fs := flag.NewFlagSet("inspect", flag.ExitOnError)
+	isGraph := fs.Bool("graph", true, "read the items as the neighbour lists of a graph")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: nnsearch inspect [options] file...\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("no file given")
+	}
+
+	for _, filename := range fs.Args() {
+		err := inspectFile(filename, *isGraph)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func inspectFile(filename string, isGraph bool) error {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+
+	ff, err := nnsearch.OpenFrozenFile(filename)
+	if err != nil {
+		return err
+	}
+	n := int(ff.GetCount())
+	offsetSize := ff.OffsetSize()
+	ff.Close()
+
+	fmt.Printf("%s\n", filename)
+	fmt.Printf("  size:        %d bytes\n", info.Size())
+	fmt.Printf("  items:       %d\n", n)
+	fmt.Printf("  offset size: %d bytes\n", offsetSize)
+
+	if !isGraph || n == 0 {
+		return nil
+	}
+
+	layers := nnsearch.LayersFilename(filename)
+	if _, err := os.Stat(layers); err == nil {
+		fmt.Printf("  layers:      %s\n", layers)
+	}
+
+	index, err := nnsearch.LoadGraphIndex(filename, nil)
+	if err != nil {
+		return err
+	}
+	g := index.(nnsearch.IGraph)
+
+	out := make([]int, n)
+	edges := 0
+	for u := 0; u < n; u++ {
+		neighbours := g.GetNeighbours(u)
+		out[u] = len(neighbours)
+		edges += len(neighbours)
+	}
+
+	sort.Ints(out)
+	fmt.Printf("  edges:       %d\n", edges)
+	fmt.Printf("  out-degree:  min %d, median %d, max %d, mean %.2f\n",
+		out[0], out[n/2], out[n-1], float64(edges)/float64(n))
+	return nil
+}
@@ -0,0 +1,167 @@
+// Command nnsearch builds, queries, inspects and evaluates nearest neighbour
+// graph indices.
+//
+// Usage:
+//
+//	nnsearch build -input vectors.bin -out graph.dat
+//	nnsearch query -input vectors.bin -index graph.dat < queries.jsonl
+//	nnsearch inspect graph.dat
+//	nnsearch eval -input vectors.bin -index graph.dat
+//	nnsearch transform -input vectors.bin -out reduced.bin -dims 100 -normalize
+//
+// Vectors are read from word2vec binary files, or from .fvecs and .bvecs
+// files.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/smhanov/nnsearch"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands = []command{
+	{"build", "build a graph over vectors and save it", build},
+	{"query", "answer queries read from stdin as JSON lines", query},
+	{"inspect", "print the header and degree statistics of a frozen file", inspect},
+	{"eval", "measure recall against a brute force search", evaluate},
+	{"transform", "write normalized or reduced word vectors", transform},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: nnsearch <command> [options]\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", c.name, c.usage)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun nnsearch <command> -h for the options of a command.\n")
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	for _, c := range commands {
+		if c.name == os.Args[1] {
+			err := c.run(os.Args[2:])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "nnsearch %s: %v\n", c.name, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	usage()
+	os.Exit(2)
+}
+
+// inputFlags are the options shared by commands that read vectors.
+type inputFlags struct {
+	input    string
+	format   string
+	distance string
+	quiet    bool
+}
+
+func (in *inputFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&in.input, "input", "", "file of vectors")
+	fs.StringVar(&in.format, "format", "auto", "format of the input: word2vec, fvecs, bvecs or auto to choose by extension")
+	fs.StringVar(&in.distance, "distance", "euclidean", "distance between vectors: euclidean or cosine")
+	fs.BoolVar(&in.quiet, "quiet", false, "do not log progress")
+}
+
+// open reads the vectors named by the flags as a space.
+func (in *inputFlags) open() (nnsearch.MetricSpace, error) {
+	if in.quiet {
+		log.SetOutput(ioutil.Discard)
+	}
+
+	if in.input == "" {
+		return nil, fmt.Errorf("no -input file given")
+	}
+
+	var distance nnsearch.VectorDistance
+	switch in.distance {
+	case "euclidean":
+		distance = nnsearch.EuclideanDistance
+	case "cosine":
+		distance = nnsearch.CosineDistance
+	default:
+		return nil, fmt.Errorf("unknown distance %q", in.distance)
+	}
+
+	format := in.format
+	if format == "auto" {
+		switch strings.ToLower(filepath.Ext(in.input)) {
+		case ".fvecs":
+			format = "fvecs"
+		case ".bvecs":
+			format = "bvecs"
+		default:
+			format = "word2vec"
+		}
+	}
+
+	switch format {
+	case "fvecs":
+		return nnsearch.OpenFvecs(in.input, distance)
+	case "bvecs":
+		return nnsearch.OpenBvecs(in.input, distance)
+	case "word2vec":
+		if _, err := os.Stat(in.input); err != nil {
+			return nil, err
+		}
+		return nnsearch.OpenWordVecs(in.input), nil
+	}
+	return nil, fmt.Errorf("unknown format %q", in.format)
+}
+
+// loadGraph opens a graph saved by build.
+func loadGraph(filename string, space nnsearch.MetricSpace) (nnsearch.SpaceIndex, error) {
+	index, err := nnsearch.LoadGraphIndex(filename, space)
+	if err != nil {
+		return nil, err
+	}
+
+	if n := index.(nnsearch.IGraph).GetNodeCount(); n != space.Length() {
+		return nil, fmt.Errorf("%s has %d nodes but the input has %d vectors", filename, n, space.Length())
+	}
+	return index, nil
+}
+
+func build(args []string) error {
+	var in inputFlags
+	var options nnsearch.GraphOptions
+	fs := flag.NewFlagSet("build", flag.ExitOnError)
+	in.register(fs)
+	out := fs.String("out", "graph.dat", "file to save the graph to")
+	fs.IntVar(&options.K, "k", 50, "number of nearest neighbours found for each node")
+	fs.BoolVar(&options.Layers, "layers", false, "build navigation layers for choosing entry points")
+	fs.IntVar(&options.LayerDegree, "layer-degree", 16, "number of neighbours of each node in the navigation layers")
+	fs.BoolVar(&options.Prune, "prune", false, "prune edges to diversify the neighbours of each node")
+	fs.Float64Var(&options.PruneAlpha, "alpha", 1.2, "alpha parameter of pruning")
+	fs.IntVar(&options.MaxDegree, "max-degree", 0, "maximum degree after pruning, defaults to k")
+	fs.Parse(args)
+
+	space, err := in.open()
+	if err != nil {
+		return err
+	}
+
+	g := nnsearch.NewGraphIndexWithOptions(space, &options)
+	g.Save(*out)
+	return nil
+}
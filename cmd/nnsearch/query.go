@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/smhanov/nnsearch"
+)
+
+// A query read from stdin. Exactly one of Vector, ID and Word is given.
+type queryRequest struct {
+	Vector []float32 `json:"vector"`
+	ID     *int      `json:"id"`
+	Word   string    `json:"word"`
+	K      int       `json:"k"`
+}
+
+type queryResult struct {
+	Index    int     `json:"index"`
+	Distance float64 `json:"distance"`
+	Word     string  `json:"word,omitempty"`
+}
+
+type queryResponse struct {
+	Results []queryResult `json:"results"`
+	Error   string        `json:"error,omitempty"`
+}
+
+func query(args []string) error {
+	var in inputFlags
+	var options nnsearch.SearchOptions
+	fs := flag.NewFlagSet("query", flag.ExitOnError)
+	in.register(fs)
+	index := fs.String("index", "graph.dat", "graph saved by build")
+	k := fs.Int("k", 10, "number of neighbours returned when a query does not give k")
+	fs.Float64Var(&options.Epsilon, "epsilon", 1.1, "how far beyond the results the search expands")
+	fs.IntVar(&options.Budget, "budget", 0, "maximum number of points visited by each query, or 0 for no limit")
+	fs.Parse(args)
+
+	space, err := in.open()
+	if err != nil {
+		return err
+	}
+
+	g, err := loadGraph(*index, space)
+	if err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	enc := json.NewEncoder(out)
+
+	for scanner.Scan() {
+		var req queryRequest
+		var resp queryResponse
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			resp.Error = err.Error()
+		} else if target, err := queryPoint(space, &req); err != nil {
+			resp.Error = err.Error()
+		} else {
+			n := req.K
+			if n <= 0 {
+				n = *k
+			}
+			for _, r := range g.NearestNeighbours(target, n, &options) {
+				resp.Results = append(resp.Results, queryResult{
+					Index:    r.Index,
+					Distance: r.Distance,
+					Word:     wordOf(r.Point),
+				})
+			}
+		}
+
+		if err := enc.Encode(&resp); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+// queryPoint returns the point that a query searches for.
+func queryPoint(space nnsearch.MetricSpace, req *queryRequest) (nnsearch.Point, error) {
+	wv, isWords := space.(*nnsearch.WordVecs)
+	switch {
+	case req.ID != nil:
+		if *req.ID < 0 || *req.ID >= space.Length() {
+			return nil, fmt.Errorf("id %d out of range", *req.ID)
+		}
+		return space.At(*req.ID), nil
+
+	case req.Word != "":
+		if !isWords {
+			return nil, fmt.Errorf("words can only be looked up in word2vec files")
+		}
+		vec := wv.Get(req.Word)
+		if vec == nil {
+			return nil, fmt.Errorf("unknown word %q", req.Word)
+		}
+		return &nnsearch.WordVector{Word: req.Word, Vector: vec}, nil
+
+	case req.Vector != nil:
+		if space.Length() > 0 && len(req.Vector) != len(nnsearch.VectorOf(space.At(0))) {
+			return nil, fmt.Errorf("vector has %d dimensions, expected %d",
+				len(req.Vector), len(nnsearch.VectorOf(space.At(0))))
+		}
+		if isWords {
+			return &nnsearch.WordVector{Vector: req.Vector}, nil
+		}
+		return &nnsearch.DenseVector{Index: -1, Vector: req.Vector}, nil
+	}
+
+	return nil, fmt.Errorf("query has no vector, id or word")
+}
+
+func wordOf(pt nnsearch.Point) string {
+	if wv, ok := pt.(*nnsearch.WordVector); ok {
+		return wv.Word
+	}
+	return ""
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/smhanov/nnsearch"
+)
+
+// transform writes a normalized or reduced copy of a word2vec file.
+func transform(args []string) error {
+	var options nnsearch.TransformOptions
+	fs := flag.NewFlagSet("transform", flag.ExitOnError)
+	input := fs.String("input", "", "word2vec binary file of vectors")
+	out := fs.String("out", "", "file to write the transformed vectors to")
+	method := fs.String("method", "pca", "reduction method: pca or random")
+	quiet := fs.Bool("quiet", false, "do not log progress")
+	fs.BoolVar(&options.Center, "center", false, "subtract the mean vector")
+	fs.BoolVar(&options.Normalize, "normalize", false, "scale the vectors to unit length")
+	fs.IntVar(&options.Dims, "dims", 0, "number of dimensions to reduce to, 0 to keep them")
+	fs.IntVar(&options.Sample, "sample", 100000, "number of vectors sampled to fit the transform")
+	fs.Int64Var(&options.Seed, "seed", 0, "seed of the sample and of random projections")
+	fs.Parse(args)
+
+	if *quiet {
+		log.SetOutput(ioutil.Discard)
+	}
+
+	if *input == "" || *out == "" {
+		return fmt.Errorf("both -input and -out are needed")
+	}
+
+	switch *method {
+	case "pca":
+		options.Method = nnsearch.PCA
+	case "random":
+		options.Method = nnsearch.RandomProjection
+	default:
+		return fmt.Errorf("unknown method %q", *method)
+	}
+
+	if _, err := os.Stat(*input); err != nil {
+		return err
+	}
+	wv := nnsearch.OpenWordVecs(*input)
+	defer wv.Close()
+
+	t, err := nnsearch.FitTransform(wv, &options)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+
+	err = t.WriteWordVecs(wv, f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -3,13 +3,14 @@ package nnsearch
 import (
 	"fmt"
 	"os"
+	"sync/atomic"
 	"time"
 )
 
 // Counter ...
 type Counter struct {
 	start time.Time
-	count int
+	count int64
 	freq  int
 }
 
@@ -23,8 +24,8 @@ func NewCounter(freq int) *Counter {
 
 // Count ...
 func (c *Counter) Count() {
-	c.count++
-	if c.count%c.freq == 0 {
-		fmt.Fprintf(os.Stderr, "%d (%.1f items/s)\r", c.count, float64(c.count)/(time.Since(c.start).Seconds()))
+	count := atomic.AddInt64(&c.count, 1)
+	if count%int64(c.freq) == 0 {
+		fmt.Fprintf(os.Stderr, "%d (%.1f items/s)\r", count, float64(count)/(time.Since(c.start).Seconds()))
 	}
 }
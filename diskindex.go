@@ -0,0 +1,446 @@
+package nnsearch
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"sync"
+)
+
+// Blocks of a disk index start at this offset, after the frozen header.
+const diskBlockStart = 4096
+
+// diskHeader describes the layout of a disk index. It is stored as the only
+// item of a frozen file, and the node blocks follow it at diskBlockStart.
+type diskHeader struct {
+	Count     int
+	Dims      int
+	MaxDegree int
+	BlockSize int
+	Entry     int
+}
+
+func (h *diskHeader) Encode(w io.Writer) uint64 {
+	l := WriteThing(w, h.Count)
+	l += WriteThing(w, h.Dims)
+	l += WriteThing(w, h.MaxDegree)
+	l += WriteThing(w, h.BlockSize)
+	l += WriteThing(w, h.Entry)
+	return l
+}
+
+func (h *diskHeader) Decode(r ByteInputStream) {
+	ReadThing(r, &h.Count)
+	ReadThing(r, &h.Dims)
+	ReadThing(r, &h.MaxDegree)
+	ReadThing(r, &h.BlockSize)
+	ReadThing(r, &h.Entry)
+}
+
+// diskBlockSize returns the size of a block holding a vector and its
+// neighbour list. Blocks smaller than a page are rounded up to a power of two
+// so that none straddles a page, and larger ones to a whole number of pages.
+func diskBlockSize(dims, maxDegree int) int {
+	size := 4*dims + 4 + 4*maxDegree
+	if size > 4096 {
+		return (size + 4095) / 4096 * 4096
+	}
+
+	block := 64
+	for block < size {
+		block *= 2
+	}
+	return block
+}
+
+// WriteDiskIndex writes the graph in a layout for searching from disk, where
+// the full precision vector and neighbour list of each node are stored
+// together in one aligned block, so that visiting a node costs a single read.
+// At most maxDegree of the closest neighbours of each node are kept.
+func WriteDiskIndex(w io.Writer, g IGraph, maxDegree int) (int64, error) {
+	n := g.GetNodeCount()
+	if n == 0 {
+		return 0, fmt.Errorf("cannot write an empty disk index")
+	}
+
+	if maxDegree < 1 {
+		return 0, fmt.Errorf("cannot write a disk index with a maximum degree of %d", maxDegree)
+	}
+
+	header := diskHeader{
+		Count:     n,
+		Dims:      len(VectorOf(g.GetNode(0))),
+		MaxDegree: maxDegree,
+		Entry:     approximateMedoid(g, 10000),
+	}
+	header.BlockSize = diskBlockSize(header.Dims, maxDegree)
+
+	l := FreezeItems(w, []FrozenItem{&header})
+	written := int64(l)
+	if l > diskBlockStart {
+		return written, fmt.Errorf("disk index header too large")
+	}
+
+	_, err := w.Write(make([]byte, diskBlockStart-l))
+	if err != nil {
+		return written, err
+	}
+	written = diskBlockStart
+
+	block := make([]byte, header.BlockSize)
+	for u := 0; u < n; u++ {
+		vec := VectorOf(g.GetNode(u))
+		if len(vec) != header.Dims {
+			return written, fmt.Errorf("node %d has %d dimensions, expected %d", u, len(vec), header.Dims)
+		}
+
+		neighbours := append([]edge(nil), g.GetNeighbours(u)...)
+		sort.Slice(neighbours, func(a, b int) bool {
+			return neighbours[a].distance < neighbours[b].distance
+		})
+		if len(neighbours) > maxDegree {
+			neighbours = neighbours[:maxDegree]
+		}
+
+		for i := range block {
+			block[i] = 0
+		}
+
+		for j, x := range vec {
+			binary.LittleEndian.PutUint32(block[4*j:], math.Float32bits(x))
+		}
+
+		at := 4 * header.Dims
+		binary.LittleEndian.PutUint32(block[at:], uint32(len(neighbours)))
+		for j, e := range neighbours {
+			binary.LittleEndian.PutUint32(block[at+4+4*j:], uint32(e.index))
+		}
+
+		c, err := w.Write(block)
+		written += int64(c)
+		if err != nil {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
+
+// SaveDiskIndex writes the graph to a file with WriteDiskIndex.
+func SaveDiskIndex(filename string, g IGraph, maxDegree int) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	bw := bufio.NewWriter(file)
+	_, err = WriteDiskIndex(bw, g, maxDegree)
+	if err != nil {
+		return err
+	}
+
+	return bw.Flush()
+}
+
+// approximateMedoid returns the node of a random sample that is closest to
+// the centroid of the sample.
+func approximateMedoid(g IGraph, samples int) int {
+	n := g.GetNodeCount()
+	if samples > n {
+		samples = n
+	}
+
+	sample := rand.Perm(n)[:samples]
+	var centroid []float64
+	for _, u := range sample {
+		vec := VectorOf(g.GetNode(u))
+		if centroid == nil {
+			centroid = make([]float64, len(vec))
+		}
+		for j, x := range vec {
+			centroid[j] += float64(x) / float64(samples)
+		}
+	}
+
+	mean := make([]float32, len(centroid))
+	for j, x := range centroid {
+		mean[j] = float32(x)
+	}
+
+	best := ArgmaxFn(samples, func(i int) float64 {
+		return -squaredDistance(VectorOf(g.GetNode(sample[i])), mean)
+	})
+	return sample[best]
+}
+
+// Options for searching a disk index. All options are optional.
+type DiskIndexOptions struct {
+	// Number of blocks read together in each step of the search. Defaults to
+	// 4.
+	BeamWidth int
+
+	// Number of candidates kept during the search. Larger lists improve
+	// recall at the cost of more reads. Defaults to 100, and is never less
+	// than the number of results requested.
+	ListSize int
+}
+
+func getDiskIndexOptions(in *DiskIndexOptions) *DiskIndexOptions {
+	var out DiskIndexOptions
+	if in != nil {
+		out = *in
+	}
+
+	if out.BeamWidth <= 0 {
+		out.BeamWidth = 4
+	}
+
+	if out.ListSize <= 0 {
+		out.ListSize = 100
+	}
+
+	return &out
+}
+
+// DiskIndex is a graph index searched from a file written by WriteDiskIndex,
+// reading only the blocks of the nodes it visits.
+type DiskIndex struct {
+	ff       *FrozenFile
+	header   diskHeader
+	nav      MetricSpace
+	distance VectorDistance
+	opt      *DiskIndexOptions
+}
+
+// OpenDiskIndex opens a file written by WriteDiskIndex. The search navigates
+// using nav, a compressed space held in memory, such as a PQSpace over the same
+// points, and only reads the blocks of the nodes it visits. The vectors read
+// from those blocks are compared to the query using the distance function to
+// rank the results.
+func OpenDiskIndex(filename string, nav MetricSpace, distance VectorDistance, options *DiskIndexOptions) (*DiskIndex, error) {
+	if nav == nil || distance == nil {
+		return nil, fmt.Errorf("a disk index needs a navigation space and a distance")
+	}
+
+	ff, err := OpenFrozenFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	di := &DiskIndex{
+		ff:       ff,
+		nav:      nav,
+		distance: distance,
+		opt:      getDiskIndexOptions(options),
+	}
+	ff.GetItem(0, &di.header)
+
+	err = di.check()
+	if err != nil {
+		ff.Close()
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+
+	return di, nil
+}
+
+// check returns an error if the header does not describe a valid layout that
+// fits in the file, or does not match the navigation space.
+func (di *DiskIndex) check() error {
+	h := di.header
+	switch {
+	case h.Count < 1 || h.Dims < 1 || h.MaxDegree < 1:
+		return fmt.Errorf("invalid header %+v", h)
+	case h.BlockSize != diskBlockSize(h.Dims, h.MaxDegree):
+		return fmt.Errorf("block size %d does not fit %d dimensions and %d neighbours",
+			h.BlockSize, h.Dims, h.MaxDegree)
+	case h.Entry < 0 || h.Entry >= h.Count:
+		return fmt.Errorf("entry point %d out of range", h.Entry)
+	case di.nav.Length() != h.Count:
+		return fmt.Errorf("%d nodes but the navigation space has %d points", h.Count, di.nav.Length())
+	}
+
+	last := make([]byte, 1)
+	_, err := di.ff.ReadAt(last, int64(diskBlockStart+h.Count*h.BlockSize-1))
+	if err != nil {
+		return fmt.Errorf("truncated blocks: %v", err)
+	}
+	return nil
+}
+
+// readBlock reads the vector and neighbour list of a node.
+func (di *DiskIndex) readBlock(u int) ([]float32, []int) {
+	block := make([]byte, di.header.BlockSize)
+	_, err := di.ff.ReadAt(block, int64(diskBlockStart+u*di.header.BlockSize))
+	if err != nil {
+		log.Panic(err)
+	}
+
+	vec := make([]float32, di.header.Dims)
+	for j := range vec {
+		vec[j] = math.Float32frombits(binary.LittleEndian.Uint32(block[4*j:]))
+	}
+
+	at := 4 * di.header.Dims
+	degree := int(binary.LittleEndian.Uint32(block[at:]))
+	if degree > di.header.MaxDegree {
+		log.Panicf("node %d has %d neighbours, more than the maximum of %d", u, degree, di.header.MaxDegree)
+	}
+
+	neighbours := make([]int, degree)
+	for j := range neighbours {
+		neighbours[j] = int(binary.LittleEndian.Uint32(block[at+4+4*j:]))
+	}
+
+	return vec, neighbours
+}
+
+func (di *DiskIndex) Length() int {
+	return di.header.Count
+}
+
+func (di *DiskIndex) At(i int) Point {
+	vec, _ := di.readBlock(i)
+	return &DenseVector{
+		Index:  i,
+		Vector: vec,
+	}
+}
+
+func (di *DiskIndex) Distance(p1, p2 Point) float64 {
+	return di.distance(VectorOf(p1), VectorOf(p2))
+}
+
+type diskCandidate struct {
+	index    int
+	distance float64
+	expanded bool
+}
+
+// NearestNeighbours performs a beam search. At each step the closest
+// candidates that have not been expanded are read from disk together. Their
+// neighbours are ranked by their distance in the navigation space, and the
+// nodes read are ranked by their full precision distance to form the results.
+// A Budget in the options limits the number of nodes read.
+func (di *DiskIndex) NearestNeighbours(target Point, k int, options *SearchOptions) []PointDistance {
+	opt := getOptions(options)
+	vec := VectorOf(target)
+	query := prepareQuery(di.nav, target)
+
+	listSize := di.opt.ListSize
+	if listSize < k {
+		listSize = k
+	}
+
+	visited := map[int]bool{di.header.Entry: true}
+	list := []diskCandidate{{
+		index:    di.header.Entry,
+		distance: di.nav.Distance(di.nav.At(di.header.Entry), query),
+	}}
+	entryDistance := list[0].distance
+
+	var results []PointDistance
+	expanded := 0
+	for opt.Ctx.Err() == nil {
+		width := di.opt.BeamWidth
+		if opt.Budget > 0 && opt.Budget-expanded < width {
+			width = opt.Budget - expanded
+		}
+
+		var beam []int
+		for i := range list {
+			if width <= 0 {
+				break
+			}
+			if !list[i].expanded {
+				list[i].expanded = true
+				beam = append(beam, i)
+				if len(beam) == width {
+					break
+				}
+			}
+		}
+
+		if len(beam) == 0 {
+			break
+		}
+
+		vectors := make([][]float32, len(beam))
+		neighbours := make([][]int, len(beam))
+		var wg sync.WaitGroup
+		for b, i := range beam {
+			wg.Add(1)
+			go func(b, u int) {
+				vectors[b], neighbours[b] = di.readBlock(u)
+				wg.Done()
+			}(b, list[i].index)
+		}
+		wg.Wait()
+		expanded += len(beam)
+
+		for b, i := range beam {
+			pt := &DenseVector{
+				Index:  list[i].index,
+				Vector: vectors[b],
+			}
+			if opt.Filter(pt) {
+				results = append(results, PointDistance{
+					Index:    pt.Index,
+					Point:    pt,
+					Distance: di.distance(vectors[b], vec),
+				})
+			}
+		}
+
+		for _, nbrs := range neighbours {
+			for _, v := range nbrs {
+				if visited[v] {
+					continue
+				}
+				visited[v] = true
+				list = append(list, diskCandidate{
+					index:    v,
+					distance: di.nav.Distance(di.nav.At(v), query),
+				})
+			}
+		}
+
+		sort.SliceStable(list, func(a, b int) bool {
+			return list[a].distance < list[b].distance
+		})
+		if len(list) > listSize {
+			list = list[:listSize]
+		}
+	}
+
+	sort.Slice(results, func(a, b int) bool {
+		return results[a].Distance < results[b].Distance
+	})
+	if len(results) > k {
+		results = results[:k]
+	}
+
+	if opt.Stats != nil {
+		opt.Stats.Visited += len(visited)
+		opt.Stats.Expanded += expanded
+		opt.Stats.EntryPoints++
+		opt.Stats.EntryDistance = entryDistance
+	}
+
+	return results
+}
+
+func (di *DiskIndex) Write(w io.Writer) (int64, error) {
+	return 0, fmt.Errorf("cannot write disk index")
+}
+
+func (di *DiskIndex) Close() error {
+	return di.ff.Close()
+}
@@ -0,0 +1,86 @@
+package nnsearch
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+)
+
+func TestDiskIndex(t *testing.T) {
+	vectors := randomVectors(5000, 16)
+	space := NewVectorSpace(vectors, EuclideanDistance)
+	g := NewGraphIndexWithOptions(space, &GraphOptions{K: 32, Prune: true, MaxDegree: 24})
+
+	err := SaveDiskIndex("disktest.dat", g, 24)
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove("disktest.dat")
+
+	pq := TrainProductQuantizer(space, 8, 2000)
+	di, err := OpenDiskIndex("disktest.dat", NewPQSpace(pq, space), EuclideanDistance, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer di.Close()
+
+	if di.Length() != space.Length() {
+		log.Panicf("Disk index has %d nodes, expected %d", di.Length(), space.Length())
+	}
+	for _, u := range []int{0, 1234, 4999} {
+		if d := EuclideanDistance(VectorOf(di.At(u)), vectors[u]); d != 0 {
+			log.Panicf("Vector %d read back at distance %v", u, d)
+		}
+	}
+
+	// navigating by compressed vectors and ranking by the full ones that are
+	// read finds about as many neighbours as the graph in memory
+	exact := NewBruteForceIndex(space)
+	queries := randomVectors(100, 16)
+	r1 := meanRecall(g, exact, queries, 10, nil)
+	var stats SearchStats
+	r2 := meanRecall(di, exact, queries, 10, &SearchOptions{Stats: &stats})
+	t.Logf("recall %v in memory, %v from disk, %+v", r1, r2, stats)
+	if r2 < 0.9 || r2 < r1-0.05 {
+		log.Panicf("Recall %v from disk, %v in memory", r2, r1)
+	}
+	if stats.Expanded == 0 || stats.Expanded > stats.Visited || stats.EntryPoints != len(queries) {
+		log.Panicf("Unexpected stats %+v", stats)
+	}
+
+	// a budget limits the nodes read for each query
+	for _, budget := range []int{1, 5, 20} {
+		stats = SearchStats{}
+		results := di.NearestNeighbours(space.At(7), 10, &SearchOptions{Budget: budget, Stats: &stats})
+		if stats.Expanded != budget || len(results) != minInt(budget, 10) {
+			log.Panicf("Read %d nodes and found %d with a budget of %d", stats.Expanded, len(results), budget)
+		}
+	}
+
+	// invalid parameters and files are refused
+	if err = SaveDiskIndex("disktest.dat", g, 0); err == nil {
+		log.Panic("Wrote a disk index with no neighbours")
+	}
+
+	err = SaveDiskIndex("disktest.dat", g, 24)
+	if err != nil {
+		panic(err)
+	}
+	small := NewVectorSpace(vectors[:100], EuclideanDistance)
+	if _, err = OpenDiskIndex("disktest.dat", small, EuclideanDistance, nil); err == nil {
+		log.Panic("Opened a disk index with a navigation space of the wrong size")
+	}
+
+	data, err := ioutil.ReadFile("disktest.dat")
+	if err != nil {
+		panic(err)
+	}
+	err = ioutil.WriteFile("disktest.dat", data[:len(data)-100], 0644)
+	if err != nil {
+		panic(err)
+	}
+	if _, err = OpenDiskIndex("disktest.dat", space, EuclideanDistance, nil); err == nil {
+		log.Panic("Opened a truncated disk index")
+	}
+}
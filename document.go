@@ -0,0 +1,82 @@
+package nnsearch
+
+// Document is a point of a DocumentSpace: the words of a text and the
+// identifier it has outside of the space.
+type Document struct {
+	ID    string
+	Words []string
+
+	// If not nil, the document is compared by the weights of its distinct
+	// words, as by WmdCalc.ComputeWeighted, instead of by its list of words.
+	Weights map[string]float64
+}
+
+func (d *Document) String() string {
+	return d.ID
+}
+
+// DocumentSpace is a space of documents compared by the Word Mover's Distance,
+// over which graphs, pivots and the other indices can be built.
+type DocumentSpace struct {
+	calc *WmdCalc
+	docs []*Document
+
+	// the words and weights of each document, and the position of each
+	histograms []histogram
+	positions  map[*Document]int
+}
+
+// NewDocumentSpace returns a space of the documents, which should already be
+// tokenized. Their words and weights are read once, so the documents must not
+// be changed afterwards.
+func NewDocumentSpace(calc *WmdCalc, docs []*Document) *DocumentSpace {
+	ds := &DocumentSpace{
+		calc:       calc,
+		docs:       docs,
+		histograms: make([]histogram, len(docs)),
+		positions:  make(map[*Document]int, len(docs)),
+	}
+
+	for i, d := range docs {
+		ds.positions[d] = i
+	}
+
+	ForkLoop(len(docs), func(i int) {
+		ds.histograms[i] = calc.histogram(docs[i])
+	})
+	return ds
+}
+
+// histogram returns the words and weights of a document of the space, or of a
+// query.
+func (ds *DocumentSpace) histogram(d *Document) histogram {
+	if i, ok := ds.positions[d]; ok {
+		return ds.histograms[i]
+	}
+	return ds.calc.histogram(d)
+}
+
+func (ds *DocumentSpace) Length() int {
+	return len(ds.docs)
+}
+
+func (ds *DocumentSpace) At(i int) Point {
+	return ds.docs[i]
+}
+
+func (ds *DocumentSpace) Distance(p1, p2 Point) float64 {
+	d1 := p1.(*Document)
+	d2 := p2.(*Document)
+	if d1 == d2 {
+		return 0
+	}
+	return ds.calc.computeHistograms(ds.histogram(d1), ds.histogram(d2))
+}
+
+// Query returns a document of the words of a text, to search for.
+func (ds *DocumentSpace) Query(text string) *Document {
+	return &Document{
+		ID:    text,
+		Words: Tokenize(text),
+	}
+}
@@ -0,0 +1,126 @@
+package nnsearch
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+// topicDocuments writes word vectors for words of three topics to filename,
+// each near the centre of its topic, and returns 90 documents of four words.
+// Document i is about topic i%3.
+func topicDocuments(filename string) (*WordVecs, []*Document) {
+	centres := randomVectors(3, 8)
+	var words []string
+	var vectors [][]float32
+	for i, noise := range randomVectors(60, 8) {
+		for j := range noise {
+			noise[j] = 2*centres[i%3][j] - 1 + (noise[j]-0.5)/4
+		}
+		words = append(words, fmt.Sprintf("t%dw%d", i%3, i))
+		vectors = append(vectors, noise)
+	}
+
+	f, err := os.Create(filename)
+	if err != nil {
+		panic(err)
+	}
+	if err = WriteWordVecs(f, words, vectors); err != nil {
+		panic(err)
+	}
+	f.Close()
+
+	docs := make([]*Document, 90)
+	for i := range docs {
+		docs[i] = &Document{ID: fmt.Sprintf("doc%d", i)}
+		for j := 0; j < 4; j++ {
+			docs[i].Words = append(docs[i].Words, words[(i%3)+3*rand.Intn(20)])
+		}
+	}
+	return OpenWordVecs(filename), docs
+}
+
+func TestDocumentSpace(t *testing.T) {
+	defer os.Remove("documenttest.bin")
+	wv, docs := topicDocuments("documenttest.bin")
+	defer wv.Close()
+	calc := NewWordMoverDistanceCalculator(wv)
+	space := NewDocumentSpace(calc, docs)
+
+	// the precomputed histograms give the distance of the words
+	for i := 1; i < 10; i++ {
+		if d, exact := space.Distance(space.At(0), space.At(i)), calc.Compute(docs[0].Words, docs[i].Words); math.Abs(d-exact) > 1e-9 {
+			log.Panicf("Distance to document %d is %v, expected %v", i, d, exact)
+		}
+	}
+
+	// the neighbours of a document are about the same topic
+	g := NewGraphIndexWithOptions(space, &GraphOptions{K: 10})
+	bf := NewBruteForceIndex(space)
+	for _, index := range []SpaceIndex{g, bf} {
+		results := index.NearestNeighbours(space.At(4), 5, nil)
+		for _, r := range results {
+			if r.Index%3 != 4%3 {
+				log.Panicf("Neighbour %v of %v is about another topic", r.Point, docs[4])
+			}
+		}
+	}
+
+	pivots := ChooseKPivots(space, 4)
+	radius := ComputeMedianDistance(space, 200)
+	within := pivots.RangeQueryByIndex(space, 7, radius, nil)
+	all := bf.NearestNeighbours(space.At(7), space.Length(), nil)
+	count := 0
+	for _, r := range all {
+		if r.Index != 7 && r.Distance <= radius {
+			count++
+		}
+	}
+	if len(within) != count {
+		log.Panicf("Pivots found %d documents in range, expected %d", len(within), count)
+	}
+
+	if q := space.Query("Hello, world"); q.ID != "Hello, world" || len(q.Words) != 2 {
+		log.Panicf("Unexpected query document %+v", q)
+	}
+}
+
+func TestDocumentIndex(t *testing.T) {
+	defer os.Remove("documentindex.bin")
+	wv, docs := topicDocuments("documentindex.bin")
+	defer wv.Close()
+	calc := NewWordMoverDistanceCalculator(wv)
+
+	// the document index finds the same neighbours as brute force while
+	// computing fewer distances
+	space := NewDocumentSpace(calc, docs)
+	var stats SearchStats
+	found := NewDocumentIndex(space).NearestNeighbours(space.At(11), 5, &SearchOptions{Stats: &stats})
+	expected := NewBruteForceIndex(space).NearestNeighbours(space.At(11), 5, nil)
+	for i := range expected {
+		if math.Abs(found[i].Distance-expected[i].Distance) > 1e-9 {
+			log.Panicf("Document index found %v, expected %v", found, expected)
+		}
+	}
+	if stats.Visited >= space.Length() {
+		log.Panicf("Computed %d distances, no fewer than brute force", stats.Visited)
+	}
+
+	// and so it does for weighted documents
+	weighted := make([]*Document, len(docs))
+	for i, d := range docs {
+		weighted[i] = &Document{ID: d.ID, Words: d.Words,
+			Weights: TermWeights(d.Words, func(word string) float64 { return float64(len(word)) })}
+	}
+	space = NewDocumentSpace(calc, weighted)
+	found = NewDocumentIndex(space).NearestNeighbours(space.At(11), 5, nil)
+	expected = NewBruteForceIndex(space).NearestNeighbours(space.At(11), 5, nil)
+	for i := range expected {
+		if math.Abs(found[i].Distance-expected[i].Distance) > 1e-9 {
+			log.Panicf("Document index found %v, expected %v", found, expected)
+		}
+	}
+}
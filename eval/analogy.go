@@ -0,0 +1,140 @@
+package eval
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/smhanov/nnsearch"
+)
+
+// CategoryResult holds the accuracy on one category of analogy questions.
+type CategoryResult struct {
+	Category string `json:"category"`
+
+	// Questions with a word missing from the vectors are skipped, and not
+	// counted in the accuracy.
+	Questions int     `json:"questions"`
+	Skipped   int     `json:"skipped"`
+	Correct   int     `json:"correct"`
+	Accuracy  float64 `json:"accuracy"`
+}
+
+// AnalogyReport holds the accuracy on a file of analogy questions, in total
+// and for each category in the order of the file.
+type AnalogyReport struct {
+	Total      CategoryResult   `json:"total"`
+	Categories []CategoryResult `json:"categories"`
+}
+
+// WriteJSON writes the report as indented JSON.
+func (r *AnalogyReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+type analogyQuestion struct {
+	category int
+	words    [4]string
+}
+
+// readAnalogies reads questions in the format of the questions-words.txt file
+// of word2vec: lines of ": category" start a category, and the other lines
+// hold four words a b c d, where a is to b as c is to d.
+func readAnalogies(r io.Reader, lowercase bool) ([]string, []analogyQuestion, error) {
+	var categories []string
+	var questions []analogyQuestion
+
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, ":") {
+			categories = append(categories, strings.TrimSpace(line[1:]))
+			continue
+		}
+
+		if lowercase {
+			line = strings.ToLower(line)
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 4 {
+			return nil, nil, fmt.Errorf("line %d: expected 4 words, got %d", lineNumber, len(fields))
+		}
+
+		if len(categories) == 0 {
+			categories = append(categories, "")
+		}
+
+		q := analogyQuestion{category: len(categories) - 1}
+		copy(q.words[:], fields)
+		questions = append(questions, q)
+	}
+	return categories, questions, scanner.Err()
+}
+
+// Analogies answers each question of a file in the format of questions-words.txt
+// by finding the nearest word to b - a + c in the index, and reports the
+// fraction answered correctly for each category. The words of the file are
+// lowercased first if lowercase is set.
+func Analogies(wv *nnsearch.WordVecs, index nnsearch.SpaceIndex, r io.Reader, lowercase bool,
+	options *nnsearch.AnalogyOptions) (*AnalogyReport, error) {
+
+	categories, questions, err := readAnalogies(r, lowercase)
+	if err != nil {
+		return nil, err
+	}
+
+	// 0 for a wrong answer, 1 for a correct one and -1 for a skipped question
+	outcomes := make([]int, len(questions))
+	nnsearch.ForkLoop(len(questions), func(i int) {
+		w := questions[i].words
+		results, err := wv.Analogy(index, []string{w[1], w[2]}, []string{w[0]}, 1, options)
+		if err != nil || wv.Get(w[3]) == nil {
+			outcomes[i] = -1
+		} else if len(results) > 0 && results[0].Point.(*nnsearch.WordVector).Word == w[3] {
+			outcomes[i] = 1
+		}
+	})
+
+	report := &AnalogyReport{
+		Total:      CategoryResult{Category: "total"},
+		Categories: make([]CategoryResult, len(categories)),
+	}
+	for i, name := range categories {
+		report.Categories[i].Category = name
+	}
+
+	for i, q := range questions {
+		for _, c := range []*CategoryResult{&report.Total, &report.Categories[q.category]} {
+			c.Questions++
+			switch outcomes[i] {
+			case -1:
+				c.Skipped++
+			case 1:
+				c.Correct++
+			}
+		}
+	}
+
+	accuracy(&report.Total)
+	for i := range report.Categories {
+		accuracy(&report.Categories[i])
+	}
+	return report, nil
+}
+
+func accuracy(c *CategoryResult) {
+	if answered := c.Questions - c.Skipped; answered > 0 {
+		c.Accuracy = float64(c.Correct) / float64(answered)
+	}
+}
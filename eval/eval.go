@@ -0,0 +1,250 @@
+// Package eval measures the recall and speed of nearest neighbour indices
+// against an exact brute force search.
+package eval
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/smhanov/nnsearch"
+)
+
+// Setting is a named set of search options to evaluate.
+type Setting struct {
+	Name    string
+	Options nnsearch.SearchOptions
+}
+
+// Grid returns a setting for every combination of the given values of
+// Epsilon and Budget. A nil slice leaves the option at its default.
+func Grid(epsilons []float64, budgets []int) []Setting {
+	if len(epsilons) == 0 {
+		epsilons = []float64{0}
+	}
+
+	if len(budgets) == 0 {
+		budgets = []int{0}
+	}
+
+	var settings []Setting
+	for _, epsilon := range epsilons {
+		for _, budget := range budgets {
+			settings = append(settings, Setting{
+				Name: fmt.Sprintf("epsilon=%v,budget=%v", epsilon, budget),
+				Options: nnsearch.SearchOptions{
+					Epsilon: epsilon,
+					Budget:  budget,
+				},
+			})
+		}
+	}
+	return settings
+}
+
+// SampleQueries returns n points chosen at random from the space.
+func SampleQueries(space nnsearch.MetricSpace, n int) []nnsearch.Point {
+	return sampleQueries(space, n, rand.Intn)
+}
+
+// SampleQueriesWithSeed returns n points chosen at random from the space,
+// the same ones for the same seed, so that ground truth cached for them can
+// be reused by a later run.
+func SampleQueriesWithSeed(space nnsearch.MetricSpace, n int, seed int64) []nnsearch.Point {
+	return sampleQueries(space, n, rand.New(rand.NewSource(seed)).Intn)
+}
+
+func sampleQueries(space nnsearch.MetricSpace, n int, intn func(int) int) []nnsearch.Point {
+	queries := make([]nnsearch.Point, n)
+	for i := range queries {
+		queries[i] = space.At(intn(space.Length()))
+	}
+	return queries
+}
+
+// Points returns all of the points of a space, such as the query vectors of a
+// benchmark read with nnsearch.OpenFvecs.
+func Points(space nnsearch.MetricSpace) []nnsearch.Point {
+	points := make([]nnsearch.Point, space.Length())
+	for i := range points {
+		points[i] = space.At(i)
+	}
+	return points
+}
+
+// Result holds the measurements of one setting.
+type Result struct {
+	Setting string  `json:"setting"`
+	Epsilon float64 `json:"epsilon"`
+	Budget  int     `json:"budget"`
+	K       int     `json:"k"`
+	Queries int     `json:"queries"`
+
+	// The fraction of the true k nearest neighbours that were returned.
+	Recall float64 `json:"recall"`
+
+	// The mean of the reciprocal rank of the true nearest neighbour in the
+	// results, counting zero when it is missing.
+	MRR float64 `json:"mrr"`
+
+	// The mean ratio of the distance of each result to the distance of the
+	// true neighbour of the same rank. It is 1 for exact results.
+	DistanceRatio float64 `json:"distance_ratio"`
+
+	QPS float64 `json:"qps"`
+
+	// Latency percentiles in milliseconds.
+	LatencyP50 float64 `json:"latency_p50_ms"`
+	LatencyP90 float64 `json:"latency_p90_ms"`
+	LatencyP99 float64 `json:"latency_p99_ms"`
+
+	// The mean number of points visited by each query, where the index
+	// reports it.
+	Visited float64 `json:"visited"`
+}
+
+// Run searches the index for the k nearest neighbours of each query using
+// each setting in turn, and compares the results with the ground truth.
+// Queries are run one at a time so that their latency can be measured.
+func Run(index nnsearch.SpaceIndex, queries []nnsearch.Point, truth *GroundTruth, k int, settings []Setting) ([]Result, error) {
+	if len(truth.Neighbours) != len(queries) {
+		return nil, fmt.Errorf("ground truth has %d queries, expected %d", len(truth.Neighbours), len(queries))
+	}
+
+	if truth.K < k {
+		return nil, fmt.Errorf("ground truth has %d neighbours per query, expected at least %d", truth.K, k)
+	}
+
+	if len(settings) == 0 {
+		settings = []Setting{{Name: "default"}}
+	}
+
+	distances := truth.distances(index, queries)
+	var results []Result
+	for _, setting := range settings {
+		results = append(results, run(index, queries, truth, distances, k, setting))
+	}
+	return results, nil
+}
+
+func run(index nnsearch.SpaceIndex, queries []nnsearch.Point, truth *GroundTruth, distances [][]float64, k int, setting Setting) Result {
+	var stats nnsearch.SearchStats
+	options := setting.Options
+	options.Stats = &stats
+
+	result := Result{
+		Setting: setting.Name,
+		Epsilon: setting.Options.Epsilon,
+		Budget:  setting.Options.Budget,
+		K:       k,
+		Queries: len(queries),
+	}
+
+	latencies := make([]time.Duration, len(queries))
+	found, expected, ratios := 0, 0, 0
+	var total time.Duration
+	for q, query := range queries {
+		start := time.Now()
+		nearest := index.NearestNeighbours(query, k, &options)
+		latencies[q] = time.Since(start)
+		total += latencies[q]
+
+		// lists read from a file may be shorter than k
+		list := truth.Neighbours[q]
+		if len(list) > k {
+			list = list[:k]
+		}
+		expected += len(list)
+
+		want := make(map[int]bool)
+		for _, u := range list {
+			want[u] = true
+		}
+
+		for rank, r := range nearest {
+			if want[r.Index] {
+				found++
+			}
+
+			if len(list) > 0 && r.Index == list[0] {
+				result.MRR += 1 / float64(rank+1)
+			}
+
+			if rank < len(list) && rank < len(distances[q]) {
+				exact := distances[q][rank]
+				if exact > 0 {
+					result.DistanceRatio += r.Distance / exact
+					ratios++
+				} else if r.Distance == 0 {
+					result.DistanceRatio++
+					ratios++
+				}
+			}
+		}
+	}
+
+	n := len(queries)
+	if expected > 0 {
+		result.Recall = float64(found) / float64(expected)
+	}
+	if n > 0 {
+		result.MRR /= float64(n)
+		result.Visited = float64(stats.Visited) / float64(n)
+		result.QPS = float64(n) / total.Seconds()
+
+		sort.Slice(latencies, func(a, b int) bool {
+			return latencies[a] < latencies[b]
+		})
+		result.LatencyP50 = percentile(latencies, 0.5)
+		result.LatencyP90 = percentile(latencies, 0.9)
+		result.LatencyP99 = percentile(latencies, 0.99)
+	}
+
+	if ratios > 0 {
+		result.DistanceRatio /= float64(ratios)
+	}
+
+	return result
+}
+
+// percentile returns a percentile of sorted latencies in milliseconds.
+func percentile(sorted []time.Duration, p float64) float64 {
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return float64(sorted[i]) / float64(time.Millisecond)
+}
+
+// WriteJSON writes the results as a JSON array.
+func WriteJSON(w io.Writer, results []Result) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(results)
+}
+
+// WriteCSV writes the results as CSV with a header row.
+func WriteCSV(w io.Writer, results []Result) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"setting", "epsilon", "budget", "k", "queries", "recall", "mrr",
+		"distance_ratio", "qps", "latency_p50_ms", "latency_p90_ms", "latency_p99_ms", "visited"})
+
+	f := func(x float64) string {
+		return strconv.FormatFloat(x, 'g', 6, 64)
+	}
+
+	for _, r := range results {
+		cw.Write([]string{r.Setting, f(r.Epsilon), strconv.Itoa(r.Budget), strconv.Itoa(r.K),
+			strconv.Itoa(r.Queries), f(r.Recall), f(r.MRR), f(r.DistanceRatio), f(r.QPS),
+			f(r.LatencyP50), f(r.LatencyP90), f(r.LatencyP99), f(r.Visited)})
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,148 @@
+package eval
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/smhanov/nnsearch"
+)
+
+func TestRun(t *testing.T) {
+	vectors := make([][]float32, 2000)
+	for i := range vectors {
+		vectors[i] = make([]float32, 8)
+		for j := range vectors[i] {
+			vectors[i][j] = rand.Float32()
+		}
+	}
+
+	space := nnsearch.NewVectorSpace(vectors, nnsearch.EuclideanDistance)
+	queries := SampleQueries(space, 50)
+
+	dir, err := ioutil.TempDir("", "eval")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "truth.dat")
+	truth, err := CachedGroundTruth(filename, space, queries, 10)
+	if err != nil {
+		panic(err)
+	}
+
+	cached, err := CachedGroundTruth(filename, space, queries, 10)
+	if err != nil {
+		panic(err)
+	}
+	if cached.K != 10 || cached.Neighbours[3][0] != truth.Neighbours[3][0] {
+		log.Panicf("Cached ground truth differs")
+	}
+
+	// queries sampled with the same seed reuse the cache
+	seeded := SampleQueriesWithSeed(space, 50, 7)
+	if Fingerprint(seeded) != Fingerprint(SampleQueriesWithSeed(space, 50, 7)) {
+		log.Panicf("Queries sampled with the same seed differ")
+	}
+
+	// the cache is recomputed for other queries of the same number
+	other := SampleQueries(space, 50)
+	recomputed, err := CachedGroundTruth(filename, space, other, 10)
+	if err != nil {
+		panic(err)
+	}
+	if recomputed.Queries != Fingerprint(other) || recomputed.Queries == truth.Queries {
+		log.Panicf("Ground truth cached for other queries was reused")
+	}
+	if recomputed.Neighbours[3][0] != nnsearch.NewBruteForceIndex(space).NearestNeighbours(other[3], 1, nil)[0].Index {
+		log.Panicf("Recomputed ground truth is wrong")
+	}
+
+	// lists shorter than k, as read from a file, do not fail the comparison
+	short := &GroundTruth{K: 10, Neighbours: make([][]int, len(queries))}
+	for q := range queries {
+		short.Neighbours[q] = truth.Neighbours[q][:q%4]
+	}
+	results, err := Run(nnsearch.NewBruteForceIndex(space), queries, short, 10, nil)
+	if err != nil {
+		panic(err)
+	}
+	if results[0].Recall != 1 {
+		log.Panicf("Brute force should find the short lists: %+v", results[0])
+	}
+
+	results, err = Run(nnsearch.NewBruteForceIndex(space), queries, truth, 10, nil)
+	if err != nil {
+		panic(err)
+	}
+	if results[0].Recall != 1 || results[0].MRR != 1 || results[0].DistanceRatio != 1 {
+		log.Panicf("Brute force should be exact: %+v", results[0])
+	}
+
+	g := nnsearch.NewGraphIndex(space)
+	results, err = Run(g, queries, truth, 10, Grid([]float64{1, 1.5}, []int{0, 20}))
+	if err != nil {
+		panic(err)
+	}
+	if len(results) != 4 || results[2].Recall < results[3].Recall {
+		log.Panicf("Unexpected results: %+v", results)
+	}
+
+	var buf bytes.Buffer
+	err = WriteCSV(&buf, results)
+	if err != nil {
+		panic(err)
+	}
+	if strings.Count(buf.String(), "\n") != 5 {
+		log.Panicf("Expected header and 4 rows, got %s", buf.String())
+	}
+}
+
+func TestAnalogies(t *testing.T) {
+	dir, err := ioutil.TempDir("", "eval")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "words.bin")
+	f, err := os.Create(filename)
+	if err != nil {
+		panic(err)
+	}
+	err = nnsearch.WriteWordVecs(f, []string{"man", "woman", "king", "queen", "apple", "pear"}, [][]float32{
+		{1, 0, 0}, {0, 1, 0}, {1, 0, 1}, {0, 1, 1}, {0.2, 0.2, -1}, {0.3, 0.1, -1},
+	})
+	f.Close()
+	if err != nil {
+		panic(err)
+	}
+
+	wv := nnsearch.OpenWordVecs(filename)
+	defer wv.Close()
+	index := nnsearch.NewBruteForceIndex(wv)
+
+	questions := ": royalty\nMan Woman King Queen\nwoman man queen king\n: fruit\nman woman zzz queen\n"
+	for _, method := range []nnsearch.AnalogyMethod{nnsearch.CosAdd, nnsearch.CosMul} {
+		report, err := Analogies(wv, index, strings.NewReader(questions), true, &nnsearch.AnalogyOptions{Method: method})
+		if err != nil {
+			panic(err)
+		}
+
+		if report.Total.Questions != 3 || report.Total.Skipped != 1 || report.Total.Accuracy != 1 ||
+			len(report.Categories) != 2 || report.Categories[1].Skipped != 1 {
+			log.Panicf("Unexpected report %+v", report)
+		}
+	}
+
+	_, err = Analogies(wv, index, strings.NewReader(": a\nman woman king\n"), true, nil)
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		log.Panicf("Expected an error on line 2, got %v", err)
+	}
+}
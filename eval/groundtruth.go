@@ -0,0 +1,216 @@
+package eval
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"os"
+
+	"github.com/smhanov/nnsearch"
+)
+
+// GroundTruth holds the exact nearest neighbours of each query, closest
+// first. Distances may be nil, as when the neighbours were read from a file,
+// in which case they are computed when needed.
+type GroundTruth struct {
+	K          int
+	Neighbours [][]int
+	Distances  [][]float64
+
+	// Queries is the Fingerprint of the queries, or zero if it is unknown.
+	Queries uint64
+}
+
+// Fingerprint returns a hash of the queries, used to tell whether cached
+// ground truth belongs to them. Vectors are hashed by value and other points
+// by their printed form.
+func Fingerprint(queries []nnsearch.Point) uint64 {
+	h := fnv.New64a()
+	var buf [4]byte
+	for _, query := range queries {
+		if vec := nnsearch.VectorOf(query); vec != nil {
+			for _, x := range vec {
+				binary.LittleEndian.PutUint32(buf[:], math.Float32bits(x))
+				h.Write(buf[:])
+			}
+		} else {
+			fmt.Fprint(h, query)
+		}
+		h.Write([]byte{0})
+	}
+	return h.Sum64()
+}
+
+// ComputeGroundTruth finds the k nearest neighbours of each query by brute
+// force.
+func ComputeGroundTruth(space nnsearch.MetricSpace, queries []nnsearch.Point, k int) *GroundTruth {
+	truth := &GroundTruth{
+		K:          k,
+		Neighbours: make([][]int, len(queries)),
+		Distances:  make([][]float64, len(queries)),
+		Queries:    Fingerprint(queries),
+	}
+
+	bf := nnsearch.NewBruteForceIndex(space)
+	counter := nnsearch.NewCounter(100)
+	for q, query := range queries {
+		counter.Count()
+		for _, r := range bf.NearestNeighbours(query, k, nil) {
+			truth.Neighbours[q] = append(truth.Neighbours[q], r.Index)
+			truth.Distances[q] = append(truth.Distances[q], r.Distance)
+		}
+
+		// a space with fewer than k points gives shorter lists
+		if len(truth.Neighbours[q]) < truth.K {
+			truth.K = len(truth.Neighbours[q])
+		}
+	}
+
+	return truth
+}
+
+// CachedGroundTruth reads the ground truth from a file written by an earlier
+// call, if it holds at least k neighbours for the same queries. Otherwise it
+// is computed by brute force and saved to the file.
+func CachedGroundTruth(filename string, space nnsearch.MetricSpace, queries []nnsearch.Point, k int) (*GroundTruth, error) {
+	truth, err := LoadGroundTruth(filename)
+	if err == nil && truth.K >= k && len(truth.Neighbours) == len(queries) &&
+		truth.Queries == Fingerprint(queries) {
+		return truth, nil
+	} else if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	truth = ComputeGroundTruth(space, queries, k)
+	return truth, truth.Save(filename)
+}
+
+// ReadIvecsGroundTruth reads the neighbour lists of a benchmark such as
+// SIFT1M from an .ivecs file. The distances are computed when needed.
+func ReadIvecsGroundTruth(filename string) (*GroundTruth, error) {
+	lists, err := nnsearch.ReadIvecs(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	truth := &GroundTruth{Neighbours: lists}
+	for q, list := range lists {
+		if q == 0 || len(list) < truth.K {
+			truth.K = len(list)
+		}
+	}
+	return truth, nil
+}
+
+// distances returns the distance from each query to its true neighbours.
+func (truth *GroundTruth) distances(space nnsearch.MetricSpace, queries []nnsearch.Point) [][]float64 {
+	if truth.Distances != nil {
+		return truth.Distances
+	}
+
+	distances := make([][]float64, len(queries))
+	nnsearch.ForkLoop(len(queries), func(q int) {
+		distances[q] = make([]float64, len(truth.Neighbours[q]))
+		for i, u := range truth.Neighbours[q] {
+			distances[q][i] = space.Distance(queries[q], space.At(u))
+		}
+	})
+	return distances
+}
+
+type truthItem struct {
+	neighbours []int
+	distances  []float64
+}
+
+func (t *truthItem) Encode(w io.Writer) uint64 {
+	l := nnsearch.WriteThing(w, len(t.neighbours))
+	for i, u := range t.neighbours {
+		l += nnsearch.WriteThing(w, u)
+		l += nnsearch.WriteThing(w, t.distances[i])
+	}
+	return l
+}
+
+func (t *truthItem) Decode(r nnsearch.ByteInputStream) {
+	var n int
+	nnsearch.ReadThing(r, &n)
+	t.neighbours = make([]int, n)
+	t.distances = make([]float64, n)
+	for i := range t.neighbours {
+		nnsearch.ReadThing(r, &t.neighbours[i])
+		nnsearch.ReadThing(r, &t.distances[i])
+	}
+}
+
+type truthHeader struct {
+	queries uint64
+}
+
+func (h *truthHeader) Encode(w io.Writer) uint64 {
+	return nnsearch.WriteThing(w, h.queries)
+}
+
+func (h *truthHeader) Decode(r nnsearch.ByteInputStream) {
+	nnsearch.ReadThing(r, &h.queries)
+}
+
+// Save writes the ground truth to a frozen file, a header holding the
+// fingerprint of the queries followed by one item per query.
+func (truth *GroundTruth) Save(filename string) error {
+	if truth.Distances == nil {
+		return fmt.Errorf("cannot save ground truth without distances")
+	}
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	items := []nnsearch.FrozenItem{&truthHeader{truth.Queries}}
+	for q := range truth.Neighbours {
+		items = append(items, &truthItem{truth.Neighbours[q], truth.Distances[q]})
+	}
+
+	bw := bufio.NewWriter(file)
+	nnsearch.FreezeItems(bw, items)
+	return bw.Flush()
+}
+
+// LoadGroundTruth reads ground truth written by Save.
+func LoadGroundTruth(filename string) (*GroundTruth, error) {
+	ff, err := nnsearch.OpenFrozenFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer ff.Close()
+
+	n := int(ff.GetCount()) - 1
+	if n < 0 {
+		return nil, fmt.Errorf("%s: ground truth has no header", filename)
+	}
+
+	var header truthHeader
+	ff.GetItem(0, &header)
+	truth := &GroundTruth{
+		Neighbours: make([][]int, n),
+		Distances:  make([][]float64, n),
+		Queries:    header.queries,
+	}
+
+	for q := 0; q < n; q++ {
+		var item truthItem
+		ff.GetItem(q+1, &item)
+		truth.Neighbours[q] = item.neighbours
+		truth.Distances[q] = item.distances
+		if q == 0 || len(item.neighbours) < truth.K {
+			truth.K = len(item.neighbours)
+		}
+	}
+
+	return truth, nil
+}
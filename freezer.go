@@ -1,9 +1,13 @@
 package nnsearch
 
 import (
+	"bufio"
 	"encoding/binary"
 	"io"
+	"io/ioutil"
 	"log"
+	"math"
+	"os"
 
 	"golang.org/x/exp/mmap"
 )
@@ -12,6 +16,7 @@ type FrozenFile struct {
 	r      atter
 	offset uint64
 	count  int64
+	wide   bool
 }
 
 type FrozenItem interface {
@@ -19,16 +24,46 @@ type FrozenItem interface {
 	Encode(w io.Writer) uint64
 }
 
+// Files whose items end beyond 4GB store 8 byte offsets, which is marked by
+// setting this bit in the item count.
+const wideOffsets = uint64(1) << 48
+
+// freezeHeader returns the item count to write and the size of each offset.
+func freezeHeader(n int, dataSize uint64) (uint64, uint64) {
+	count := uint64(n)
+	if WriteThing(nil, count)+4*count+dataSize > math.MaxUint32 {
+		return count | wideOffsets, 8
+	}
+	return count, 4
+}
+
+func writeOffset(w io.Writer, off, size uint64) {
+	var err error
+	if size == 8 {
+		err = binary.Write(w, binary.BigEndian, off)
+	} else {
+		err = binary.Write(w, binary.BigEndian, uint32(off))
+	}
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
 func FreezeItems(w io.Writer, items []FrozenItem) uint64 {
+	sizes := make([]uint64, len(items))
+	var dataSize uint64
+	for i, item := range items {
+		sizes[i] = item.Encode(nil)
+		dataSize += sizes[i]
+	}
+
 	// write number of items
-	off := WriteThing(w, uint64(len(items))) + 4*uint64(len(items))
+	count, offsetSize := freezeHeader(len(items), dataSize)
+	off := WriteThing(w, count) + offsetSize*uint64(len(items))
 	// write offset of items
-	for _, item := range items {
-		err := binary.Write(w, binary.BigEndian, uint32(off))
-		if err != nil {
-			log.Panic(err)
-		}
-		off += item.Encode(nil)
+	for i := range items {
+		writeOffset(w, off, offsetSize)
+		off += sizes[i]
 	}
 	// write items
 	for _, item := range items {
@@ -38,21 +73,110 @@ func FreezeItems(w io.Writer, items []FrozenItem) uint64 {
 	return off
 }
 
+// FreezeItemsFrom writes n items in the format of FreezeItems, getting each
+// item from get only once, so that the items need not all be in memory at
+// the same time. The items are staged in temporary files in dir, or the
+// default directory for temporary files if dir is empty.
+func FreezeItemsFrom(w io.Writer, n int, get func(i int) FrozenItem, dir string) (uint64, error) {
+	data, err := ioutil.TempFile(dir, "freeze-data")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(data.Name())
+	defer data.Close()
+
+	offsets, err := ioutil.TempFile(dir, "freeze-offsets")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(offsets.Name())
+	defer offsets.Close()
+
+	// stage the items and their offsets relative to the first item
+	dw := bufio.NewWriter(data)
+	ow := bufio.NewWriter(offsets)
+	var dataSize uint64
+	for i := 0; i < n; i++ {
+		writeOffset(ow, dataSize, 8)
+		dataSize += get(i).Encode(dw)
+	}
+
+	if err = dw.Flush(); err != nil {
+		return 0, err
+	}
+	if err = ow.Flush(); err != nil {
+		return 0, err
+	}
+
+	count, offsetSize := freezeHeader(n, dataSize)
+	base := WriteThing(w, count) + offsetSize*uint64(n)
+
+	if _, err = offsets.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	or := bufio.NewReader(offsets)
+	for i := 0; i < n; i++ {
+		var off uint64
+		if err = binary.Read(or, binary.BigEndian, &off); err != nil {
+			return 0, err
+		}
+		writeOffset(w, base+off, offsetSize)
+	}
+
+	if _, err = data.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	if _, err = io.Copy(w, data); err != nil {
+		return 0, err
+	}
+
+	return base + dataSize, nil
+}
+
 func (ff *FrozenFile) GetCount() int64 {
 	return ff.count
 }
 
+// OffsetSize returns the size in bytes of the offsets of the items, which is
+// 8 for files larger than 4GB and 4 otherwise.
+func (ff *FrozenFile) OffsetSize() int {
+	if ff.wide {
+		return 8
+	}
+	return 4
+}
+
 func (ff *FrozenFile) GetItem(index int, item FrozenItem) {
-	offset1 := int(ff.offset + uint64(index*4))
-	offset2 := uint64(ff.r.At(offset1))<<24 |
-		uint64(ff.r.At(offset1+1))<<16 |
-		uint64(ff.r.At(offset1+2))<<8 |
-		uint64(ff.r.At(offset1+3))
+	var offset2 uint64
+	if ff.wide {
+		offset1 := int(ff.offset + uint64(index*8))
+		for i := 0; i < 8; i++ {
+			offset2 = offset2<<8 | uint64(ff.r.At(offset1+i))
+		}
+	} else {
+		offset1 := int(ff.offset + uint64(index*4))
+		offset2 = uint64(ff.r.At(offset1))<<24 |
+			uint64(ff.r.At(offset1+1))<<16 |
+			uint64(ff.r.At(offset1+2))<<8 |
+			uint64(ff.r.At(offset1+3))
+	}
 	//log.Printf("Item %v at offset %v", index, offset2)
 	bs := newByteInputStream(ff.r, offset2)
 	item.Decode(bs)
 }
 
+// ReadAt reads raw bytes from the file, for data stored after the frozen items.
+func (ff *FrozenFile) ReadAt(p []byte, off int64) (int, error) {
+	if r, ok := ff.r.(io.ReaderAt); ok {
+		return r.ReadAt(p, off)
+	}
+
+	for i := range p {
+		p[i] = ff.r.At(int(off) + i)
+	}
+	return len(p), nil
+}
+
 func (ff *FrozenFile) Close() error {
 	if c, ok := ff.r.(io.Closer); ok {
 		return c.Close()
@@ -74,6 +198,7 @@ func OpenFrozenFile(filename string) (*FrozenFile, error) {
 	return &FrozenFile{
 		offset: off,
 		r:      file,
-		count:  int64(count),
+		count:  int64(count &^ wideOffsets),
+		wide:   count&wideOffsets != 0,
 	}, nil
 }
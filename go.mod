@@ -3,6 +3,8 @@ module github.com/smhanov/nnsearch
 go 1.13
 
 require (
+	github.com/golang/protobuf v1.3.3
 	github.com/yizha/go v0.0.0-20181014043003-d7aea0d5ede2
 	golang.org/x/exp v0.0.0-20210220032938-85be41e4509f
+	google.golang.org/grpc v1.29.1
 )
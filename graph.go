@@ -12,14 +12,18 @@ import (
 	"runtime"
 	"sort"
 	"sync"
+	"sync/atomic"
 )
 
 type graph struct {
 	MetricSpace
-	Heaps   []edgeHeap
-	Checked map[pair]bool
-	lock    sync.RWMutex
-	Locks   []sync.Mutex
+	Heaps  []edgeHeap
+	Locks  []sync.Mutex
+	layers layers
+
+	// When split is set, only pairs of nodes on different sides of it are
+	// connected, as when merging two graphs.
+	split int
 }
 
 type edge struct {
@@ -64,17 +68,85 @@ func (h *minEdgeHeap) Pop() interface{} {
 	return x
 }
 
+// Options for building a graph index. All options are optional.
+type GraphOptions struct {
+	// Number of nearest neighbours found for each node. Defaults to 50.
+	K int
+
+	// Build sparse upper layers of sampled nodes, used to find good entry
+	// points into the graph when searching.
+	Layers bool
+
+	// Number of neighbours of each node in the upper layers. Each layer holds
+	// about 1/LayerDegree of the nodes of the layer below it. Defaults to 16.
+	LayerDegree int
+
+	// Diversify the edges of each node after building, dropping edges whose
+	// direction is already covered by a closer neighbour. This improves recall
+	// at a lower degree.
+	Prune bool
+
+	// The alpha of the pruning rule. 1 keeps only the edges of the relative
+	// neighbourhood graph, and larger values keep more long range edges.
+	// Defaults to 1.2.
+	PruneAlpha float64
+
+	// The maximum number of edges of a node after pruning. Defaults to K.
+	MaxDegree int
+}
+
+func getGraphOptions(in *GraphOptions) *GraphOptions {
+	var out GraphOptions
+	if in != nil {
+		out = *in
+	}
+
+	if out.K <= 0 {
+		out.K = 50
+	}
+
+	if out.LayerDegree <= 0 {
+		out.LayerDegree = 16
+	}
+
+	if out.PruneAlpha <= 0 {
+		out.PruneAlpha = 1.2
+	}
+
+	if out.MaxDegree <= 0 {
+		out.MaxDegree = out.K
+	}
+
+	return &out
+}
+
 func NewGraphIndex(space MetricSpace) *graph {
+	return NewGraphIndexWithOptions(space, nil)
+}
+
+func NewGraphIndexWithOptions(space MetricSpace, options *GraphOptions) *graph {
+	opt := getGraphOptions(options)
+	g := newGraph(space)
+	g.gradientDescentKnn(opt.K)
+
+	if opt.Prune {
+		g.prune(opt.PruneAlpha, opt.MaxDegree)
+	}
+
+	if opt.Layers {
+		g.layers = buildLayers(space, opt.LayerDegree)
+	}
+	return g
+}
+
+func newGraph(space MetricSpace) *graph {
 	g := &graph{
 		MetricSpace: space,
-		Checked:     make(map[pair]bool),
 	}
 
 	n := g.Length()
 	g.Heaps = make([]edgeHeap, n)
 	g.Locks = make([]sync.Mutex, n)
-
-	g.gradientDescentKnn(50)
 	return g
 }
 
@@ -125,7 +197,7 @@ func (g *graph) initializeUsingPivots(pivots Pivots, k int) {
 
 	order := Sequence(n)
 	sort.Slice(order, func(a, b int) bool {
-		return PivotHashLessThan(hashes[a], hashes[b])
+		return PivotHashLessThan(hashes[order[a]], hashes[order[b]])
 	})
 
 	c := NewCounter(100)
@@ -169,57 +241,62 @@ func (g *graph) bruteForce(k int) {
 }
 */
 
+// connect compares two nodes, and adds each to the neighbours of the other if
+// it is closer than the furthest of them. Pairs that are already neighbours in
+// both directions are skipped without computing their distance. Instead of a
+// shared record of the pairs checked, which every thread would contend for,
+// each node's own neighbour list is consulted under its lock, and repeated
+// comparisons are limited by the new and old flags of NN-descent.
 func (g *graph) connect(a, b, k int) int {
-	g.lock.RLock()
-	if g.Checked[pair{a, b}] || g.Checked[pair{b, a}] || a == b {
-		g.lock.RUnlock()
+	if a == b || (g.split > 0 && (a < g.split) == (b < g.split)) {
+		return 0
+	}
+
+	if g.hasNeighbour(a, b) && g.hasNeighbour(b, a) {
 		return 0
 	}
-	g.lock.RUnlock()
 
-	c := 0
 	l := g.Distance(g.At(a), g.At(b))
+	return g.insert(a, b, l, k) + g.insert(b, a, l, k)
+}
 
-	g.Locks[a].Lock()
-	if g.Heaps[a].Len() < k {
-		heap.Push(&g.Heaps[a], edge{b, l, true})
-		c++
-	} else if l < g.Heaps[a][0].distance {
-		heap.Pop(&g.Heaps[a])
-		heap.Push(&g.Heaps[a], edge{b, l, true})
-		c++
+// hasNeighbour returns whether v is in the neighbour list of u.
+func (g *graph) hasNeighbour(u, v int) bool {
+	g.Locks[u].Lock()
+	defer g.Locks[u].Unlock()
+	for _, e := range g.Heaps[u] {
+		if e.index == v {
+			return true
+		}
 	}
+	return false
+}
 
-	g.Locks[a].Unlock()
-	g.Locks[b].Lock()
+// insert adds v to the neighbours of u at distance l, unless it is already
+// there or is further than all k of them. It returns the number of changes.
+func (g *graph) insert(u, v int, l float64, k int) int {
+	g.Locks[u].Lock()
+	defer g.Locks[u].Unlock()
 
-	if g.Heaps[b].Len() < k {
-		heap.Push(&g.Heaps[b], edge{a, l, true})
-		c++
-	} else if l < g.Heaps[b][0].distance {
-		heap.Pop(&g.Heaps[b])
-		heap.Push(&g.Heaps[b], edge{a, l, true})
-		c++
+	h := &g.Heaps[u]
+	if h.Len() >= k && l >= (*h)[0].distance {
+		return 0
 	}
 
-	g.Locks[b].Unlock()
-
-	if c != 0 {
-		g.lock.Lock()
-		if len(g.Checked) >= 1000000 {
-			g.Checked = make(map[pair]bool)
+	for _, e := range *h {
+		if e.index == v {
+			return 0
 		}
-		g.Checked[pair{a, b}] = true
-		g.lock.Unlock()
 	}
 
-	return c
+	if h.Len() >= k {
+		heap.Pop(h)
+	}
+	heap.Push(h, edge{v, l, true})
+	return 1
 }
 
 func (g *graph) descentStep(k int, maxSample int, iter int) int {
-	// keep track of checked pairs
-	g.Checked = make(map[pair]bool)
-
 	// find reverse graph
 	n := g.Length()
 	rev := make([][]edge, n)
@@ -227,15 +304,15 @@ func (g *graph) descentStep(k int, maxSample int, iter int) int {
 	for u := 0; u < n; u++ {
 		for _, e := range g.Heaps[u] {
 			rev[e.index] = append(rev[e.index], edge{u, e.distance, e.mark})
-			g.Checked[pair{u, e.index}] = true
 		}
 	}
 
 	// for each node,
-	c := 0
+	var c int64
 	counter := NewCounter(100)
 	ForkLoop(n, func(u int) {
 		counter.Count()
+		changes := 0
 
 		// find lists of old neighbours, new neighbours
 		var old []int
@@ -273,22 +350,23 @@ func (g *graph) descentStep(k int, maxSample int, iter int) int {
 			v := new[i]
 			for j := i + 1; j < len(new); j++ {
 				w := new[j]
-				c += g.connect(v, w, k)
+				changes += g.connect(v, w, k)
 			}
 
 			for _, w := range old {
 				if v != w {
-					c += g.connect(v, w, k)
+					changes += g.connect(v, w, k)
 				}
 			}
 		}
+		atomic.AddInt64(&c, int64(changes))
 	})
 
 	for i := 0; i < 10; i++ {
 		runtime.GC()
 	}
 
-	return c
+	return int(c)
 }
 
 func (g *graph) gradientDescentKnn(kIn int) {
@@ -297,7 +375,7 @@ func (g *graph) gradientDescentKnn(kIn int) {
 	log.Printf("Choosing %d pivots", np)
 	pivots := ChoosePivots(g)
 
-	k := 50
+	k := kIn
 	log.Printf("Initialize using pivots")
 	g.initializeUsingPivots(pivots, k)
 
@@ -367,6 +445,14 @@ func (g *graph) NearestNeighbours(target Point, k int, options *SearchOptions) [
 	return NearestNeighbours(g, target, k, options)
 }
 
+func (g *graph) PrepareQuery(target Point) Point {
+	return prepareQuery(g.MetricSpace, target)
+}
+
+func (g *graph) EntryPoints(target Point, count int, stats *SearchStats) []int {
+	return g.layers.entryPoints(g, target, count, stats)
+}
+
 /*
 func pushk(h heap.Interface, x interface{}, k int) {
 	if h.Len() < k {
@@ -427,7 +513,8 @@ func (g *graph) makeUndirected(k int) {
 
 type frozenGraph struct {
 	MetricSpace
-	ff *FrozenFile
+	ff     *FrozenFile
+	layers layers
 }
 
 func (g *frozenGraph) GetNodeCount() int {
@@ -463,6 +550,14 @@ func (g *frozenGraph) NearestNeighbours(target Point, k int, options *SearchOpti
 	return NearestNeighbours(g, target, k, options)
 }
 
+func (g *frozenGraph) PrepareQuery(target Point) Point {
+	return prepareQuery(g.MetricSpace, target)
+}
+
+func (g *frozenGraph) EntryPoints(target Point, count int, stats *SearchStats) []int {
+	return g.layers.entryPoints(g, target, count, stats)
+}
+
 func (g *frozenGraph) GetNode(index int) Point {
 	return g.At(index)
 }
@@ -471,6 +566,11 @@ func (g *frozenGraph) Write(w io.Writer) (int64, error) {
 	return 0, fmt.Errorf("cannot write frozen graph")
 }
 
+// Close unmaps the file of the graph. The space of the graph is not closed.
+func (g *frozenGraph) Close() error {
+	return g.ff.Close()
+}
+
 type IGraph interface {
 	MetricSpace
 	GetNodeCount() int
@@ -478,37 +578,41 @@ type IGraph interface {
 	GetNode(index int) Point
 }
 
-/*
-func randomSample(n, k int) []int {
-	have := make(map[int]bool)
-	var results []int
-
-	for len(results) < k {
-		choice := rand.Intn(n)
-		if _, ok := have[choice]; !ok {
-			have[choice] = true
-			results = append(results, choice)
-		}
-	}
-	return results
+// entryPointer is implemented by graphs that can choose where a search for the
+// target should start.
+type entryPointer interface {
+	EntryPoints(target Point, count int, stats *SearchStats) []int
 }
-*/
 
 func NearestNeighbours(g IGraph, target Point, k int, optionsIn *SearchOptions) []PointDistance {
 	opt := getOptions(optionsIn)
+	results, checked := nearestNeighbours(g, target, k, opt)
+	if opt.Stats != nil {
+		opt.Stats.Visited += len(checked)
+	}
+	return results
+}
+
+// nearestNeighbours searches the graph, returning the results and the nodes
+// whose distance was computed. It records all of the stats but Visited.
+func nearestNeighbours(g IGraph, target Point, k int, opt *SearchOptions) ([]PointDistance, map[int]bool) {
 	space := g
+	target = prepareQuery(g, target)
 	var bestk pointHeap
 	var queue minEdgeHeap
-	epsilon := 1.1
 	checked := make(map[int]bool)
 	n := space.Length()
 
 	var mutex sync.Mutex
 	gthreshold := math.Inf(1)
 
+	exhausted := func() bool {
+		return opt.Budget > 0 && len(checked) >= opt.Budget
+	}
+
 	consider := func(u int) bool {
 		mutex.Lock()
-		if checked[u] {
+		if checked[u] || exhausted() {
 			mutex.Unlock()
 			return false
 		}
@@ -530,26 +634,42 @@ func NearestNeighbours(g IGraph, target Point, k int, optionsIn *SearchOptions)
 				Index:    u,
 				Point:    pt,
 			})
-			gthreshold = epsilon * d
+			gthreshold = opt.Epsilon * d
 		}
 
 		heap.Push(&queue, edge{u, d, false})
 		return true
 	}
 
-	found := 0
-	for found < 10 {
+	var entries []int
+	if ep, ok := g.(entryPointer); ok {
+		entries = ep.EntryPoints(target, 10, opt.Stats)
+	}
+
+	for _, u := range entries {
+		consider(u)
+	}
+
+	found := len(entries)
+	for found < 10 && found < n && !exhausted() {
 		if consider(rand.Intn(n)) {
 			found++
 		}
 	}
 
+	entryDistance := math.Inf(1)
+	for _, e := range queue {
+		entryDistance = math.Min(entryDistance, e.distance)
+	}
+
+	expanded := 0
+
 	ForkWhile(func() bool {
 		if opt.Ctx.Err() != nil {
 			return false
 		}
 		mutex.Lock()
-		if len(queue) == 0 {
+		if len(queue) == 0 || exhausted() {
 			mutex.Unlock()
 			return false
 		}
@@ -559,6 +679,7 @@ func NearestNeighbours(g IGraph, target Point, k int, optionsIn *SearchOptions)
 			mutex.Unlock()
 			return false
 		}
+		expanded++
 		mutex.Unlock()
 		for _, e := range g.GetNeighbours(item.index) {
 			consider(e.index)
@@ -575,9 +696,83 @@ func NearestNeighbours(g IGraph, target Point, k int, optionsIn *SearchOptions)
 		return bestk[a].Index > bestk[b].Index
 	})
 
+	if opt.Stats != nil {
+		opt.Stats.Expanded += expanded
+		opt.Stats.EntryPoints += found
+		opt.Stats.EntryDistance = entryDistance
+	}
+
 	log.Printf("Searched %.1f%% of graph",
 		float64(len(checked))/float64(g.GetNodeCount())*100)
-	return bestk
+	return bestk, checked
+}
+
+// RangeSearch returns the points of the graph within radius of the target,
+// closest first. It finds the nearest neighbour of the target, then explores
+// the graph outwards from it, expanding every node that is within epsilon
+// times the radius. Points that are only connected to the rest of the range
+// through nodes further than this may be missed.
+func RangeSearch(g IGraph, target Point, radius float64, optionsIn *SearchOptions) []PointDistance {
+	opt := getOptions(optionsIn)
+	nearestOptions := *opt
+	nearestOptions.Filter = nil
+	nearest, visited := nearestNeighbours(g, target, 1, getOptions(&nearestOptions))
+	target = prepareQuery(g, target)
+
+	var results []PointDistance
+	var queue []int
+	checked := make(map[int]bool)
+	for _, r := range nearest {
+		checked[r.Index] = true
+		queue = append(queue, r.Index)
+		if r.Distance <= radius && opt.Filter(r.Point) {
+			results = append(results, r)
+		}
+	}
+
+	expanded := 0
+	for len(queue) > 0 && opt.Ctx.Err() == nil {
+		u := queue[0]
+		queue = queue[1:]
+		expanded++
+
+		for _, e := range g.GetNeighbours(u) {
+			if checked[e.index] || opt.Budget > 0 && len(checked) >= opt.Budget {
+				continue
+			}
+			checked[e.index] = true
+
+			pt := g.At(e.index)
+			d := g.Distance(pt, target)
+			if d <= radius && opt.Filter(pt) {
+				results = append(results, PointDistance{
+					Index:    e.index,
+					Point:    pt,
+					Distance: d,
+				})
+			}
+
+			if d <= opt.Epsilon*radius {
+				queue = append(queue, e.index)
+			}
+		}
+	}
+
+	sort.Slice(results, func(a, b int) bool {
+		return results[a].Distance < results[b].Distance
+	})
+
+	// nodes checked by both searches are visited once
+	for u := range visited {
+		checked[u] = true
+	}
+
+	if opt.Stats != nil {
+		opt.Stats.Visited += len(checked)
+		opt.Stats.Expanded += expanded
+	}
+
+	return results
 }
 
 /*
@@ -629,6 +824,29 @@ func (g *graph) Save(filename string) {
 	if err != nil {
 		log.Panic(err)
 	}
+
+	if g.layers != nil {
+		g.saveLayers(filename)
+	} else {
+		// layers saved with an earlier graph of the same name would be
+		// loaded with this one
+		os.Remove(LayersFilename(filename))
+	}
+}
+
+func (g *graph) saveLayers(filename string) {
+	file, err := os.Create(LayersFilename(filename))
+	if err != nil {
+		log.Panic(err)
+	}
+	defer file.Close()
+
+	bw := bufio.NewWriter(file)
+	defer bw.Flush()
+	_, err = g.layers.Write(bw)
+	if err != nil {
+		log.Panic(err)
+	}
 }
 
 func LoadGraphIndex(filename string, space MetricSpace) (SpaceIndex, error) {
@@ -636,8 +854,16 @@ func LoadGraphIndex(filename string, space MetricSpace) (SpaceIndex, error) {
 	if err != nil {
 		return nil, err
 	}
+
+	layers, err := loadLayers(filename, int(ff.GetCount()))
+	if err != nil {
+		ff.Close()
+		return nil, err
+	}
+
 	return &frozenGraph{
 		MetricSpace: space,
 		ff:          ff,
+		layers:      layers,
 	}, nil
 }
@@ -0,0 +1,226 @@
+package nnsearch
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+)
+
+// BenchmarkBuild measures the rate of graph construction for a number of
+// threads. Run it with, for example:
+//
+//	go test -run XXX -bench Build -cpu 4,8,16,32,64
+//
+// Replacing the shared Checked map with per-node duplicate checks was only
+// measured on a single core machine, 20000 points of 32 dimensions, K 20:
+//
+//	                  -cpu 1          -cpu 4
+//	shared map        1190 points/s   1119 points/s
+//	per-node checks   2418 points/s   1930 points/s
+//
+// With one core, -cpu 4 shows the cost of contention rather than scaling. How
+// the build scales from 4 to 64 cores has not been measured.
+func BenchmarkBuild(b *testing.B) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	space := NewVectorSpace(randomVectors(20000, 32), EuclideanDistance)
+	b.ResetTimer()
+
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		NewGraphIndexWithOptions(space, &GraphOptions{K: 20})
+	}
+
+	b.ReportMetric(float64(b.N*space.Length())/time.Since(start).Seconds(), "points/s")
+}
+
+func TestAnalyze(t *testing.T) {
+	space := NewVectorSpace(randomVectors(2000, 8), EuclideanDistance)
+	g := NewGraphIndexWithOptions(space, &GraphOptions{K: 10})
+
+	var report GraphReport
+	var buf bytes.Buffer
+	err := Analyze(g, nil).WriteJSON(&buf)
+	if err != nil {
+		panic(err)
+	}
+	err = json.Unmarshal(buf.Bytes(), &report)
+	if err != nil {
+		panic(err)
+	}
+
+	if report.Nodes != 2000 || report.WeakComponents != 1 || report.Unreachable != 0 {
+		log.Panicf("Unexpected report: %s", buf.String())
+	}
+
+	if report.Recall < 0.9 {
+		log.Panicf("Recall %v too low", report.Recall)
+	}
+
+	count, largest := strongComponents([][]int{{1}, {0}, {3}, {}})
+	if count != 3 || largest != 2 {
+		log.Panicf("Expected 3 strong components, largest 2, got %d, %d", count, largest)
+	}
+}
+
+func TestLayers(t *testing.T) {
+	// with a layer degree of 4 the first layer holds a quarter of the points,
+	// so its neighbours are found by building a graph rather than by brute
+	// force
+	space := NewVectorSpace(randomVectors(12000, 4), EuclideanDistance)
+	g := NewGraphIndexWithOptions(space, &GraphOptions{K: 10, Layers: true, LayerDegree: 4})
+	plain := NewGraphIndexWithOptions(space, &GraphOptions{K: 10})
+
+	if len(g.layers) < 2 || len(g.layers[0].nodes) <= bruteForceLayerSize {
+		log.Panicf("Expected a first layer of more than %d nodes, got %d layers", bruteForceLayerSize, len(g.layers))
+	}
+
+	first := g.layers[0]
+	sampled := make(map[int]bool)
+	for _, u := range first.nodes {
+		sampled[u] = true
+	}
+	for _, u := range first.nodes[:100] {
+		for _, v := range first.neighbours[u] {
+			if !sampled[v] || v == u {
+				log.Panicf("Node %d of the first layer has neighbour %d outside of it", u, v)
+			}
+		}
+	}
+
+	// the entry points found through the layers are much closer to the
+	// target than random ones
+	queries := randomVectors(100, 4)
+	var layered, unlayered SearchStats
+	var entryDistance, randomDistance, found float64
+	exact := NewBruteForceIndex(space)
+	for _, q := range queries {
+		target := &DenseVector{-1, q}
+		results := g.NearestNeighbours(target, 10, &SearchOptions{Stats: &layered})
+		plain.NearestNeighbours(target, 10, &SearchOptions{Stats: &unlayered})
+		entryDistance += layered.EntryDistance
+		found += recall(exact.NearestNeighbours(target, 10, nil), results)
+
+		best := math.Inf(1)
+		for _, u := range rand.Perm(space.Length())[:10] {
+			best = math.Min(best, space.Distance(space.At(u), target))
+		}
+		randomDistance += best
+	}
+
+	t.Logf("layered %+v, unlayered %+v", layered, unlayered)
+	t.Logf("mean entry distance %v, random %v, recall %v", entryDistance/100, randomDistance/100, found/100)
+	if entryDistance > randomDistance/2 || found < 90 {
+		log.Panicf("Entry distance %v, random %v, recall %v", entryDistance/100, randomDistance/100, found/100)
+	}
+	if layered.EntryPoints != 10*len(queries) || layered.LayerVisited == 0 || unlayered.LayerVisited != 0 {
+		log.Panicf("Unexpected stats: layered %+v, unlayered %+v", layered, unlayered)
+	}
+	if layered.LayerVisited > len(queries)*space.Length()/10 {
+		log.Panicf("Visited %d points of the layers in %d searches", layered.LayerVisited, len(queries))
+	}
+
+	// the layers are saved next to the graph and loaded with it
+	g.Save("layerstest.dat")
+	defer os.Remove("layerstest.dat")
+	defer os.Remove(LayersFilename("layerstest.dat"))
+	loaded, err := LoadGraphIndex("layerstest.dat", space)
+	if err != nil {
+		panic(err)
+	}
+	defer loaded.(*frozenGraph).Close()
+
+	frozen := loaded.(*frozenGraph)
+	if len(frozen.layers) != len(g.layers) {
+		log.Panicf("Loaded %d layers, saved %d", len(frozen.layers), len(g.layers))
+	}
+	for _, q := range queries[:10] {
+		target := &DenseVector{-1, q}
+		e1 := fmt.Sprint(g.EntryPoints(target, 10, nil))
+		e2 := fmt.Sprint(frozen.EntryPoints(target, 10, nil))
+		if e1 != e2 {
+			log.Panicf("Entry points %s after loading, %s before", e2, e1)
+		}
+	}
+
+	// layers of a larger graph are refused
+	small := NewGraphIndexWithOptions(NewVectorSpace(randomVectors(100, 4), EuclideanDistance), &GraphOptions{K: 5})
+	f, err := os.Create("smalltest.dat")
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove("smalltest.dat")
+	small.Write(f)
+	f.Close()
+	data, err := ioutil.ReadFile(LayersFilename("layerstest.dat"))
+	if err != nil {
+		panic(err)
+	}
+	err = ioutil.WriteFile(LayersFilename("smalltest.dat"), data, 0644)
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove(LayersFilename("smalltest.dat"))
+	if _, err = LoadGraphIndex("smalltest.dat", space); err == nil {
+		log.Panic("Loaded layers with nodes beyond the end of the graph")
+	}
+
+	// saving a graph without layers removes those of an earlier graph
+	small.Save("smalltest.dat")
+	reloaded, err := LoadGraphIndex("smalltest.dat", space)
+	if err != nil {
+		panic(err)
+	}
+	if reloaded.(*frozenGraph).layers != nil {
+		log.Panic("Loaded the layers of an earlier graph")
+	}
+	reloaded.(*frozenGraph).Close()
+
+	// searching both together adds up the stats of each
+	var stats SearchStats
+	results := SearchAll(&DenseVector{-1, queries[0]}, 10, &SearchOptions{Stats: &stats}, g, loaded)
+	if len(results) != 10 || stats.EntryPoints != 20 || stats.Visited == 0 {
+		log.Panicf("Unexpected stats %+v of %d results", stats, len(results))
+	}
+}
+
+func TestRangeSearch(t *testing.T) {
+	vectors := randomVectors(2000, 4)
+	space := NewVectorSpace(vectors, EuclideanDistance)
+	g := NewGraphIndex(space)
+	target := randomVectors(1, 4)[0]
+
+	// compare with the points in range found by brute force
+	radius := 0.2
+	within := 0
+	for _, v := range vectors {
+		if EuclideanDistance(v, target) <= radius {
+			within++
+		}
+	}
+	results := RangeSearch(g, target, radius, &SearchOptions{Epsilon: 2})
+	if len(results) < within*9/10 {
+		log.Panicf("Found %d points in range, expected %d", len(results), within)
+	}
+	for i, r := range results {
+		if r.Distance > radius || i > 0 && r.Distance < results[i-1].Distance {
+			log.Panicf("Result %d at distance %v out of order or range", i, r.Distance)
+		}
+	}
+
+	// nodes checked by both the nearest neighbour search and the range search
+	// are counted once
+	var stats SearchStats
+	results = RangeSearch(g, target, 100, &SearchOptions{Stats: &stats})
+	if len(results) != space.Length() || stats.Visited != space.Length() {
+		log.Panicf("Found %d points and visited %d, expected %d", len(results), stats.Visited, space.Length())
+	}
+}
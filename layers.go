@@ -0,0 +1,252 @@
+package nnsearch
+
+import (
+	"container/heap"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+)
+
+// layer is a sparse navigation graph over a sample of the nodes of a graph.
+// Node and neighbour indices are those of the base graph.
+type layer struct {
+	nodes      []int
+	neighbours map[int][]int
+}
+
+// layers is a hierarchy of navigation layers over a graph, in the style of
+// HNSW. Each layer holds a sample of the nodes of the layer below it, and the
+// last layer is the sparsest. Searches descend greedily through the layers to
+// find entry points into the base graph close to the target.
+type layers []*layer
+
+// The maximum size of a layer for which neighbours are found by brute force
+// instead of building a graph.
+const bruteForceLayerSize = 2000
+
+// buildLayers samples nodes for each layer with probability 1/m of appearing
+// in the layer above, and finds m neighbours for each node in each layer.
+func buildLayers(space MetricSpace, m int) layers {
+	n := space.Length()
+	if m < 2 {
+		m = 2
+	}
+
+	levels := make([]int, n)
+	ml := 1 / math.Log(float64(m))
+	for u := range levels {
+		levels[u] = int(-math.Log(1-rand.Float64()) * ml)
+	}
+
+	var result layers
+	for level := 1; ; level++ {
+		var nodes []int
+		for u, l := range levels {
+			if l >= level {
+				nodes = append(nodes, u)
+			}
+		}
+
+		if len(nodes) < 2 {
+			break
+		}
+
+		log.Printf("Building layer %d with %d nodes", level, len(nodes))
+		result = append(result, newLayer(space, nodes, m))
+	}
+
+	return result
+}
+
+func newLayer(space MetricSpace, nodes []int, m int) *layer {
+	l := &layer{
+		nodes:      nodes,
+		neighbours: make(map[int][]int, len(nodes)),
+	}
+
+	sampled := &shuffledSpace{
+		MetricSpace: space,
+		mapping:     nodes,
+	}
+
+	lists := make([][]int, len(nodes))
+	if len(nodes) <= bruteForceLayerSize {
+		ForkLoop(len(nodes), func(i int) {
+			var h edgeHeap
+			pt := sampled.At(i)
+			for j := range nodes {
+				if i == j {
+					continue
+				}
+				d := space.Distance(pt, sampled.At(j))
+				if len(h) < m || d < h[0].distance {
+					if len(h) == m {
+						heap.Pop(&h)
+					}
+					heap.Push(&h, edge{j, d, false})
+				}
+			}
+			for _, e := range h {
+				lists[i] = append(lists[i], nodes[e.index])
+			}
+		})
+	} else {
+		g := newGraph(sampled)
+		g.gradientDescentKnn(m)
+		for i := range nodes {
+			for _, e := range g.Heaps[i] {
+				lists[i] = append(lists[i], nodes[e.index])
+			}
+		}
+	}
+
+	for i, u := range nodes {
+		l.neighbours[u] = lists[i]
+	}
+
+	return l
+}
+
+// searchLayer performs a best first search of the layer starting from the
+// given entry points, and returns the ef closest nodes found, closest first.
+func (l *layer) searchLayer(space MetricSpace, target Point, entries []edge, ef int, stats *SearchStats) []edge {
+	checked := make(map[int]bool)
+	var queue minEdgeHeap
+	var best edgeHeap
+
+	for _, e := range entries {
+		checked[e.index] = true
+		heap.Push(&queue, e)
+		heap.Push(&best, e)
+		if len(best) > ef {
+			heap.Pop(&best)
+		}
+	}
+
+	for len(queue) > 0 {
+		item := heap.Pop(&queue).(edge)
+		if len(best) == ef && item.distance > best[0].distance {
+			break
+		}
+
+		for _, v := range l.neighbours[item.index] {
+			if checked[v] {
+				continue
+			}
+			checked[v] = true
+			d := space.Distance(space.At(v), target)
+			if stats != nil {
+				stats.LayerVisited++
+			}
+
+			if len(best) < ef || d < best[0].distance {
+				heap.Push(&queue, edge{v, d, false})
+				heap.Push(&best, edge{v, d, false})
+				if len(best) > ef {
+					heap.Pop(&best)
+				}
+			}
+		}
+	}
+
+	sort.Slice(best, func(a, b int) bool {
+		return best[a].distance < best[b].distance
+	})
+	return best
+}
+
+// entryPoints descends greedily from the top layer, and returns the count
+// closest nodes to the target found in the lowest layer.
+func (ls layers) entryPoints(space MetricSpace, target Point, count int, stats *SearchStats) []int {
+	if len(ls) == 0 {
+		return nil
+	}
+
+	top := ls[len(ls)-1].nodes[0]
+	entries := []edge{{top, space.Distance(space.At(top), target), false}}
+	for i := len(ls) - 1; i >= 0; i-- {
+		ef := 1
+		if i == 0 {
+			ef = count
+		}
+		entries = ls[i].searchLayer(space, target, entries, ef, stats)
+	}
+
+	result := make([]int, len(entries))
+	for i, e := range entries {
+		result[i] = e.index
+	}
+	return result
+}
+
+func (l *layer) Encode(w io.Writer) uint64 {
+	s := WriteThing(w, len(l.nodes))
+	for _, u := range l.nodes {
+		s += WriteThing(w, u)
+		s += WriteThing(w, len(l.neighbours[u]))
+		for _, v := range l.neighbours[u] {
+			s += WriteThing(w, v)
+		}
+	}
+	return s
+}
+
+func (l *layer) Decode(r ByteInputStream) {
+	var n int
+	ReadThing(r, &n)
+	l.nodes = make([]int, n)
+	l.neighbours = make(map[int][]int, n)
+	for i := range l.nodes {
+		var count int
+		ReadThing(r, &l.nodes[i])
+		ReadThing(r, &count)
+		list := make([]int, count)
+		for j := range list {
+			ReadThing(r, &list[j])
+		}
+		l.neighbours[l.nodes[i]] = list
+	}
+}
+
+func (ls layers) Write(w io.Writer) (int64, error) {
+	items := make([]FrozenItem, len(ls))
+	for i := range ls {
+		items[i] = ls[i]
+	}
+	return int64(FreezeItems(w, items)), nil
+}
+
+// LayersFilename returns the name of the file the layers of a graph are saved
+// in, alongside the graph.
+func LayersFilename(filename string) string {
+	return filename + ".layers"
+}
+
+// loadLayers reads the layers saved alongside a graph of n nodes, if there are
+// any.
+func loadLayers(filename string, n int) (layers, error) {
+	ff, err := OpenFrozenFile(LayersFilename(filename))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer ff.Close()
+
+	ls := make(layers, ff.GetCount())
+	for i := range ls {
+		ls[i] = &layer{}
+		ff.GetItem(i, ls[i])
+		for _, u := range ls[i].nodes {
+			if u < 0 || u >= n {
+				return nil, fmt.Errorf("%s: layer %d has node %d, but the graph has %d nodes",
+					LayersFilename(filename), i+1, u, n)
+			}
+		}
+	}
+	return ls, nil
+}
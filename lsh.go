@@ -0,0 +1,447 @@
+package nnsearch
+
+import (
+	"container/heap"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/bits"
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+// LSHFamily computes locality sensitive hash signatures of points.
+type LSHFamily interface {
+	// Signature returns the hash of a point in a table, together with the
+	// margin of each bit. Bits with the smallest margins are the most likely
+	// to differ for a nearby point, and are flipped first when probing.
+	Signature(pt Point, table int) (sig uint64, margins []float64)
+
+	// Tables returns the number of tables signatures are computed for.
+	Tables() int
+
+	// Bits returns the number of bits of each signature.
+	Bits() int
+}
+
+type cosineLSH struct {
+	planes [][][]float32
+}
+
+// NewCosineLSH returns a family of random hyperplane signatures for cosine
+// similarity over vectors of the given dimension.
+func NewCosineLSH(dims, tables, bits int) LSHFamily {
+	lsh := &cosineLSH{
+		planes: make([][][]float32, tables),
+	}
+
+	for t := range lsh.planes {
+		lsh.planes[t] = make([][]float32, bits)
+		for b := range lsh.planes[t] {
+			plane := make([]float32, dims)
+			for j := range plane {
+				plane[j] = float32(rand.NormFloat64())
+			}
+			lsh.planes[t][b] = plane
+		}
+	}
+
+	return lsh
+}
+
+func (lsh *cosineLSH) Tables() int {
+	return len(lsh.planes)
+}
+
+func (lsh *cosineLSH) Bits() int {
+	return len(lsh.planes[0])
+}
+
+func (lsh *cosineLSH) Signature(pt Point, table int) (uint64, []float64) {
+	vec := VectorOf(pt)
+	var sig uint64
+	margins := make([]float64, len(lsh.planes[table]))
+	for b, plane := range lsh.planes[table] {
+		d := dot(plane, vec)
+		if d >= 0 {
+			sig |= 1 << uint(b)
+		}
+		margins[b] = math.Abs(d)
+	}
+	return sig, margins
+}
+
+type hammingLSH struct {
+	positions [][]int
+}
+
+// NewHammingLSH returns a family of bit sampling signatures for the hamming
+// distance over bit vectors of the given length.
+func NewHammingLSH(length, tables, bits int) LSHFamily {
+	lsh := &hammingLSH{
+		positions: make([][]int, tables),
+	}
+
+	for t := range lsh.positions {
+		lsh.positions[t] = make([]int, bits)
+		for b := range lsh.positions[t] {
+			lsh.positions[t][b] = rand.Intn(length)
+		}
+	}
+
+	return lsh
+}
+
+func (lsh *hammingLSH) Tables() int {
+	return len(lsh.positions)
+}
+
+func (lsh *hammingLSH) Bits() int {
+	return len(lsh.positions[0])
+}
+
+func (lsh *hammingLSH) Signature(pt Point, table int) (uint64, []float64) {
+	vec := BitsOf(pt)
+	var sig uint64
+	margins := make([]float64, len(lsh.positions[table]))
+	for b, pos := range lsh.positions[table] {
+		sig |= (vec[pos/64] >> uint(pos%64) & 1) << uint(b)
+		margins[b] = 1
+	}
+	return sig, margins
+}
+
+// BitsPoint is implemented by points that are backed by a bit vector.
+type BitsPoint interface {
+	GetBits() []uint64
+}
+
+// BitVector is a point consisting of a bit vector and its index in a space.
+type BitVector struct {
+	Index int
+	Bits  []uint64
+}
+
+func (bv *BitVector) GetBits() []uint64 {
+	return bv.Bits
+}
+
+// BitsOf returns the bit vector behind a point, or nil if the point is not
+// backed by one.
+func BitsOf(pt Point) []uint64 {
+	switch v := pt.(type) {
+	case []uint64:
+		return v
+	case BitsPoint:
+		return v.GetBits()
+	}
+	return nil
+}
+
+func HammingDistance(vec1, vec2 []uint64) float64 {
+	d := 0
+	for i := range vec1 {
+		d += bits.OnesCount64(vec1[i] ^ vec2[i])
+	}
+	return float64(d)
+}
+
+type hammingSpace struct {
+	vectors [][]uint64
+}
+
+// NewHammingSpace returns a metric space over bit vectors held in memory,
+// whose points are *BitVector.
+func NewHammingSpace(vectors [][]uint64) MetricSpace {
+	return &hammingSpace{vectors}
+}
+
+func (hs *hammingSpace) Length() int {
+	return len(hs.vectors)
+}
+
+func (hs *hammingSpace) At(i int) Point {
+	return &BitVector{
+		Index: i,
+		Bits:  hs.vectors[i],
+	}
+}
+
+func (hs *hammingSpace) Distance(p1, p2 Point) float64 {
+	return HammingDistance(BitsOf(p1), BitsOf(p2))
+}
+
+// Options for locality sensitive hashing. All options are optional.
+type LSHOptions struct {
+	// Number of hash tables. Defaults to 8, or to the tables of the Family.
+	Tables int
+
+	// Number of bits in the signature of each table, at most 64. Defaults to
+	// 16.
+	Bits int
+
+	// Number of buckets probed in each table in addition to the query's own.
+	// Defaults to 8. Set it to a negative number to probe only the query's own
+	// bucket.
+	Probes int
+
+	// The hash family. Defaults to random hyperplanes over the vectors of the
+	// space. Tables and Bits are taken from the family, and must agree with it
+	// if they are set.
+	Family LSHFamily
+}
+
+func getLSHOptions(in *LSHOptions) *LSHOptions {
+	var out LSHOptions
+	if in != nil {
+		out = *in
+	}
+
+	if out.Family != nil {
+		if out.Tables > 0 && out.Tables != out.Family.Tables() ||
+			out.Bits > 0 && out.Bits != out.Family.Bits() {
+			log.Panicf("options give %d tables of %d bits, but the family has %d of %d",
+				out.Tables, out.Bits, out.Family.Tables(), out.Family.Bits())
+		}
+		out.Tables = out.Family.Tables()
+		out.Bits = out.Family.Bits()
+	}
+
+	if out.Tables <= 0 {
+		out.Tables = 8
+	}
+
+	if out.Bits <= 0 {
+		out.Bits = 16
+	} else if out.Bits > 64 {
+		out.Bits = 64
+	}
+
+	if out.Probes == 0 {
+		out.Probes = 8
+	} else if out.Probes < 0 {
+		out.Probes = 0
+	}
+
+	return &out
+}
+
+// LSHIndex is a locality sensitive hashing index, which finds candidates in
+// the buckets of the query's signatures and nearby ones, and returns the
+// closest of them.
+type LSHIndex struct {
+	MetricSpace
+	opt     *LSHOptions
+	tables  []map[uint64][]int
+	indexed int
+	lock    sync.RWMutex
+}
+
+// NewLSHIndex hashes all points of the space into the tables of a locality
+// sensitive hashing index. Points added to the space later can be indexed
+// with Add or Update, without rebuilding.
+func NewLSHIndex(space MetricSpace, options *LSHOptions) *LSHIndex {
+	opt := getLSHOptions(options)
+	if opt.Family == nil {
+		if space.Length() == 0 {
+			log.Panic("cannot choose hyperplanes for an empty space")
+		}
+
+		vec := VectorOf(space.At(0))
+		if vec == nil {
+			log.Panic("cannot choose hyperplanes for points without vectors; set the Family option")
+		}
+		opt.Family = NewCosineLSH(len(vec), opt.Tables, opt.Bits)
+	}
+
+	idx := &LSHIndex{
+		MetricSpace: space,
+		opt:         opt,
+		tables:      make([]map[uint64][]int, opt.Tables),
+	}
+
+	for t := range idx.tables {
+		idx.tables[t] = make(map[uint64][]int)
+	}
+
+	idx.Update()
+	return idx
+}
+
+// Add indexes point i of the space.
+func (idx *LSHIndex) Add(i int) {
+	pt := idx.At(i)
+	sigs := make([]uint64, len(idx.tables))
+	for t := range sigs {
+		sigs[t], _ = idx.opt.Family.Signature(pt, t)
+	}
+
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+	for t, sig := range sigs {
+		idx.tables[t][sig] = append(idx.tables[t][sig], i)
+	}
+	if i >= idx.indexed {
+		idx.indexed = i + 1
+	}
+}
+
+// Remove removes point i of the space from the index.
+func (idx *LSHIndex) Remove(i int) {
+	pt := idx.At(i)
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+	for t, table := range idx.tables {
+		sig, _ := idx.opt.Family.Signature(pt, t)
+		bucket := table[sig]
+		for j, v := range bucket {
+			if v == i {
+				bucket = append(bucket[:j], bucket[j+1:]...)
+				break
+			}
+		}
+
+		if len(bucket) == 0 {
+			delete(table, sig)
+		} else {
+			table[sig] = bucket
+		}
+	}
+}
+
+// Update indexes the points that were added to the space since the index was
+// created or last updated.
+func (idx *LSHIndex) Update() {
+	idx.lock.RLock()
+	start := idx.indexed
+	idx.lock.RUnlock()
+
+	n := idx.Length()
+	ForkLoop(n-start, func(i int) {
+		idx.Add(start + i)
+	})
+}
+
+func (idx *LSHIndex) NearestNeighbours(target Point, k int, options *SearchOptions) []PointDistance {
+	opt := getOptions(options)
+
+	have := make(map[int]bool)
+	var candidates []int
+	idx.lock.RLock()
+	for t, table := range idx.tables {
+		sig, margins := idx.opt.Family.Signature(target, t)
+		for _, probe := range probeSequence(sig, margins, idx.opt.Probes+1) {
+			for _, v := range table[probe] {
+				if !have[v] {
+					have[v] = true
+					candidates = append(candidates, v)
+				}
+			}
+		}
+	}
+	idx.lock.RUnlock()
+
+	results := make(pointHeap, 0, k)
+	var mutex sync.Mutex
+	ForkLoop(len(candidates), func(i int) {
+		if opt.Ctx.Err() != nil {
+			return
+		}
+
+		pt := idx.At(candidates[i])
+		if !opt.Filter(pt) {
+			return
+		}
+
+		dist := idx.Distance(pt, target)
+		mutex.Lock()
+		if len(results) < k || results[0].Distance > dist {
+			if len(results) == k {
+				heap.Pop(&results)
+			}
+			heap.Push(&results, PointDistance{
+				Index:    candidates[i],
+				Point:    pt,
+				Distance: dist,
+			})
+		}
+		mutex.Unlock()
+	})
+
+	sort.Slice(results, func(a, b int) bool {
+		return results[a].Distance < results[b].Distance
+	})
+
+	return results
+}
+
+func (idx *LSHIndex) Write(w io.Writer) (int64, error) {
+	return 0, fmt.Errorf("cannot write lsh index")
+}
+
+type perturbation struct {
+	bits  []int
+	score float64
+}
+
+type perturbationHeap []perturbation
+
+func (h perturbationHeap) Len() int           { return len(h) }
+func (h perturbationHeap) Less(i, j int) bool { return h[i].score < h[j].score }
+func (h perturbationHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *perturbationHeap) Push(x interface{}) {
+	*h = append(*h, x.(perturbation))
+}
+func (h *perturbationHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[0 : n-1]
+	return x
+}
+
+// probeSequence returns the signature followed by up to n-1 perturbations of
+// it, in order of increasing likelihood of missing a neighbour, using the
+// query-directed probing of Lv et al.
+func probeSequence(sig uint64, margins []float64, n int) []uint64 {
+	probes := []uint64{sig}
+	if len(margins) == 0 {
+		return probes
+	}
+
+	order := Sequence(len(margins))
+	sort.Slice(order, func(a, b int) bool {
+		return margins[order[a]] < margins[order[b]]
+	})
+
+	score := func(set []int) float64 {
+		s := 0.0
+		for _, i := range set {
+			m := margins[order[i]]
+			s += m * m
+		}
+		return s
+	}
+
+	h := perturbationHeap{{[]int{0}, score([]int{0})}}
+	for len(probes) < n && len(h) > 0 {
+		p := heap.Pop(&h).(perturbation)
+		probe := sig
+		for _, i := range p.bits {
+			probe ^= 1 << uint(order[i])
+		}
+		probes = append(probes, probe)
+
+		last := p.bits[len(p.bits)-1]
+		if last+1 < len(order) {
+			shifted := append(append([]int(nil), p.bits[:len(p.bits)-1]...), last+1)
+			expanded := append(append([]int(nil), p.bits...), last+1)
+			heap.Push(&h, perturbation{shifted, score(shifted)})
+			heap.Push(&h, perturbation{expanded, score(expanded)})
+		}
+	}
+
+	return probes
+}
@@ -0,0 +1,140 @@
+package nnsearch
+
+import (
+	"log"
+	"math/rand"
+	"testing"
+)
+
+func TestProbeSequence(t *testing.T) {
+	margins := []float64{0.5, 0.1, 0.9, 0.3}
+	probes := probeSequence(0, margins, 16)
+	if len(probes) != 16 {
+		log.Panicf("Expected all 16 probes of 4 bits, got %v", len(probes))
+	}
+
+	seen := make(map[uint64]bool)
+	for _, probe := range probes {
+		if seen[probe] {
+			log.Panicf("Probe %b repeated in %v", probe, probes)
+		}
+		seen[probe] = true
+	}
+
+	// the bits with the smallest margins are flipped first
+	if probes[0] != 0 || probes[1] != 1<<1 || probes[2] != 1<<3 || probes[3] != 1<<1|1<<3 {
+		log.Panicf("Unexpected probe order %v", probes)
+	}
+}
+
+func TestLSHIndex(t *testing.T) {
+	vectors := unitVectors(5000, 16)
+	space := NewVectorSpace(vectors, CosineDistance)
+	exact := NewBruteForceIndex(space)
+	queries := unitVectors(50, 16)
+
+	family := NewCosineLSH(16, 8, 12)
+	single := NewLSHIndex(space, &LSHOptions{Family: family, Probes: -1})
+	probed := NewLSHIndex(space, &LSHOptions{Family: family, Probes: 32})
+
+	r1 := meanRecall(single, exact, queries, 10, nil)
+	r2 := meanRecall(probed, exact, queries, 10, nil)
+	t.Logf("recall %v with one bucket per table, %v with multi-probe", r1, r2)
+	if r2 <= r1 || r2 < 0.8 {
+		log.Panicf("Multi-probe recall %v, single bucket %v", r2, r1)
+	}
+
+	// points appended to the space are found after Update, and not after
+	// they are removed
+	vs := space.(*vectorSpace)
+	vs.vectors = append(vs.vectors, queries...)
+	probed.Update()
+	for i := range queries {
+		results := probed.NearestNeighbours(&DenseVector{-1, queries[i]}, 1, nil)
+		if len(results) == 0 || results[0].Index != len(vectors)+i {
+			log.Panicf("Added point %v not found: %v", len(vectors)+i, results)
+		}
+	}
+
+	for i := range queries {
+		probed.Remove(len(vectors) + i)
+	}
+	for i := range queries {
+		for _, pd := range probed.NearestNeighbours(&DenseVector{-1, queries[i]}, 10, nil) {
+			if pd.Index >= len(vectors) {
+				log.Panicf("Removed point %v was found", pd.Index)
+			}
+		}
+	}
+
+	probed.Add(len(vectors))
+	results := probed.NearestNeighbours(&DenseVector{-1, queries[0]}, 1, nil)
+	if len(results) == 0 || results[0].Index != len(vectors) {
+		log.Panicf("Point added again not found: %v", results)
+	}
+}
+
+func TestHammingLSH(t *testing.T) {
+	// points are noisy copies of a few random centres
+	centres := make([][]uint64, 20)
+	for i := range centres {
+		centres[i] = []uint64{rand.Uint64(), rand.Uint64()}
+	}
+
+	noisy := func(centre []uint64) []uint64 {
+		vec := append([]uint64(nil), centre...)
+		for b := 0; b < 8; b++ {
+			pos := rand.Intn(128)
+			vec[pos/64] ^= 1 << uint(pos%64)
+		}
+		return vec
+	}
+
+	vectors := make([][]uint64, 2000)
+	for i := range vectors {
+		vectors[i] = noisy(centres[i%len(centres)])
+	}
+	space := NewHammingSpace(vectors)
+
+	// the tables are taken from the family
+	index := NewLSHIndex(space, &LSHOptions{Family: NewHammingLSH(128, 4, 12)})
+	if len(index.tables) != 4 {
+		log.Panicf("Index has %d tables, family 4", len(index.tables))
+	}
+	for i := 0; i < 50; i++ {
+		query := noisy(centres[i%len(centres)])
+		results := index.NearestNeighbours(&BitVector{-1, query}, 5, nil)
+		if len(results) != 5 {
+			log.Panicf("Found %v of 5 neighbours", len(results))
+		}
+
+		for _, pd := range results {
+			if pd.Distance != HammingDistance(query, vectors[pd.Index]) {
+				log.Panicf("Distance %v to %v is wrong", pd.Distance, pd.Index)
+			}
+			if pd.Index%len(centres) != i%len(centres) {
+				log.Panicf("Found %v from another cluster at distance %v", pd.Index, pd.Distance)
+			}
+		}
+	}
+
+	// without vectors the hyperplanes cannot be chosen
+	func() {
+		defer func() {
+			if recover() == nil {
+				log.Panic("Made an LSH index over bit vectors without a family")
+			}
+		}()
+		NewLSHIndex(space, nil)
+	}()
+
+	// options that disagree with the family are refused
+	func() {
+		defer func() {
+			if recover() == nil {
+				log.Panic("Made an LSH index with more tables than its family")
+			}
+		}()
+		NewLSHIndex(space, &LSHOptions{Family: NewHammingLSH(128, 4, 12), Tables: 8})
+	}()
+}
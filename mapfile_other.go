@@ -0,0 +1,10 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package nnsearch
+
+import "fmt"
+
+func mapFile(filename string) ([]byte, func() error, error) {
+	return nil, nil, fmt.Errorf("mapping files is not supported on this platform")
+}
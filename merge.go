@@ -0,0 +1,119 @@
+package nnsearch
+
+import (
+	"container/heap"
+	"log"
+	"math/rand"
+)
+
+type concatenatedSpace struct {
+	first, second MetricSpace
+}
+
+// NewConcatenatedSpace returns a space holding the points of the first space
+// followed by those of the second. Distances are computed by the first space.
+func NewConcatenatedSpace(first, second MetricSpace) MetricSpace {
+	return &concatenatedSpace{first, second}
+}
+
+func (cs *concatenatedSpace) Length() int {
+	return cs.first.Length() + cs.second.Length()
+}
+
+func (cs *concatenatedSpace) At(i int) Point {
+	n := cs.first.Length()
+	if i < n {
+		return cs.first.At(i)
+	}
+	return cs.second.At(i - n)
+}
+
+func (cs *concatenatedSpace) Distance(p1, p2 Point) float64 {
+	return cs.first.Distance(p1, p2)
+}
+
+// MergeGraphs combines two graphs, or frozen graphs, into one graph over a
+// space holding the nodes of the first graph followed by the nodes of the
+// second. If space is nil, the spaces of the two graphs are concatenated.
+//
+// The edges of both graphs are kept, and each node is linked to random nodes
+// of the other graph. NN-descent iterations then only compare pairs of nodes
+// from different graphs, so the cost depends on the edges found across the two
+// halves rather than on rebuilding either of them.
+func MergeGraphs(space MetricSpace, a, b IGraph, options *GraphOptions) *graph {
+	opt := getGraphOptions(options)
+	if space == nil {
+		space = NewConcatenatedSpace(a, b)
+	}
+
+	g := newGraph(space)
+	n1 := a.GetNodeCount()
+	n := g.Length()
+	k := opt.K
+
+	for u := 0; u < n; u++ {
+		var edges []edge
+		if u < n1 {
+			edges = a.GetNeighbours(u)
+		} else {
+			for _, e := range b.GetNeighbours(u - n1) {
+				edges = append(edges, edge{e.index + n1, e.distance, false})
+			}
+		}
+
+		g.Heaps[u] = closestEdges(edges, k)
+		for i := range g.Heaps[u] {
+			g.Heaps[u][i].mark = false
+		}
+		heap.Init(&g.Heaps[u])
+	}
+
+	// with one of the graphs empty there is nothing to link
+	if n1 > 0 && n > n1 {
+		g.linkHalves(n1, k)
+	}
+
+	g.makeUndirected(k * 2)
+
+	if opt.Prune {
+		g.prune(opt.PruneAlpha, opt.MaxDegree)
+	}
+
+	if opt.Layers {
+		g.layers = buildLayers(space, opt.LayerDegree)
+	}
+
+	return g
+}
+
+// linkHalves links each node to random nodes on the other side of n1, then
+// runs NN-descent comparing only pairs of nodes on different sides.
+func (g *graph) linkHalves(n1, k int) {
+	n := g.Length()
+	g.split = n1
+	log.Printf("Linking %d and %d nodes", n1, n-n1)
+	ForkLoop(n, func(u int) {
+		for x := 0; x < k/2; x++ {
+			var v int
+			if u < n1 {
+				v = n1 + rand.Intn(n-n1)
+			} else {
+				v = rand.Intn(n1)
+			}
+			g.connect(u, v, k)
+		}
+	})
+
+	iter := 1
+	for {
+		log.Printf("Iteration %v                  ", iter)
+		iter++
+		c := g.descentStep(k, k, iter)
+		if c == 0 {
+			break
+		}
+		log.Printf("%v changes made", c)
+	}
+
+	g.split = 0
+}
@@ -0,0 +1,56 @@
+package nnsearch
+
+import (
+	"log"
+	"testing"
+)
+
+func TestMergeGraphs(t *testing.T) {
+	vectors := randomVectors(6000, 8)
+	first := NewVectorSpace(vectors[:3000], EuclideanDistance)
+	second := NewVectorSpace(vectors[3000:], EuclideanDistance)
+	space := NewVectorSpace(vectors, EuclideanDistance)
+
+	options := &GraphOptions{K: 10}
+	a := NewGraphIndexWithOptions(first, options)
+	b := NewGraphIndexWithOptions(second, options)
+	merged := MergeGraphs(nil, a, b, options)
+	if merged.Length() != space.Length() {
+		log.Panicf("Merged graph has %d nodes, expected %d", merged.Length(), space.Length())
+	}
+
+	// the halves are mixed, so nearly every node has neighbours in the other
+	// half
+	crossing := 0
+	for u := range merged.Heaps {
+		for _, e := range merged.Heaps[u] {
+			if (u < 3000) != (e.index < 3000) {
+				crossing++
+				break
+			}
+		}
+	}
+	if crossing < space.Length()*9/10 {
+		log.Panicf("Only %d of %d nodes have edges to the other half", crossing, space.Length())
+	}
+
+	exact := NewBruteForceIndex(space)
+	queries := randomVectors(100, 8)
+	r1 := meanRecall(NewGraphIndexWithOptions(space, options), exact, queries, 10, nil)
+	r2 := meanRecall(merged, exact, queries, 10, nil)
+	t.Logf("recall %v rebuilt, %v merged", r1, r2)
+	if r2 < r1-0.05 {
+		log.Panicf("Merged recall %v, rebuilt %v", r2, r1)
+	}
+
+	// merging with an empty graph keeps the other one
+	empty := NewGraphIndexWithOptions(NewVectorSpace(nil, EuclideanDistance), options)
+	for _, g := range []*graph{MergeGraphs(nil, empty, a, options), MergeGraphs(nil, a, empty, options)} {
+		if g.Length() != first.Length() {
+			log.Panicf("Merged graph with an empty one has %d nodes, expected %d", g.Length(), first.Length())
+		}
+		if r := meanRecall(g, NewBruteForceIndex(first), queries, 10, nil); r < 0.8 {
+			log.Panicf("Merged graph with an empty one has recall %v", r)
+		}
+	}
+}
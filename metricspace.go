@@ -37,6 +37,60 @@ type SearchOptions struct {
 
 	// A method that returns true if a point is admissible.
 	Filter PointFilter
+
+	// If set, receives statistics about the search.
+	Stats *SearchStats
+
+	// Graph searches stop expanding candidates that are further from the
+	// target than Epsilon times the distance of the latest result. Larger
+	// values search more of the graph. Defaults to 1.1.
+	Epsilon float64
+
+	// The maximum number of points whose distance is computed by a graph
+	// search. Zero means no limit.
+	Budget int
+}
+
+// Statistics about a search. Counts are added to, so one SearchStats can
+// accumulate several searches, but not searches that run at the same time.
+// EntryDistance holds the value for the most recent search, or the least of
+// the indices searched together by SearchAll.
+type SearchStats struct {
+	// Number of points whose distance to the target was computed.
+	Visited int
+
+	// Number of graph nodes whose neighbours were examined.
+	Expanded int
+
+	// Number of points that the search started from, and the distance to the
+	// target of the closest of them. The closer the entry points are to the
+	// nearest neighbour, the less of the graph has to be searched.
+	EntryPoints   int
+	EntryDistance float64
+
+	// Number of points visited in the upper layers while choosing entry
+	// points.
+	LayerVisited int
+}
+
+// add adds the counts of the stats of several searches made at the same time,
+// and keeps the least of their entry distances.
+func (s *SearchStats) add(others []SearchStats) {
+	entered := false
+	for _, other := range others {
+		s.Visited += other.Visited
+		s.Expanded += other.Expanded
+		s.LayerVisited += other.LayerVisited
+		if other.EntryPoints == 0 {
+			continue
+		}
+
+		s.EntryPoints += other.EntryPoints
+		if !entered || other.EntryDistance < s.EntryDistance {
+			s.EntryDistance = other.EntryDistance
+			entered = true
+		}
+	}
 }
 
 func getOptions(in *SearchOptions) *SearchOptions {
@@ -53,6 +107,10 @@ func getOptions(in *SearchOptions) *SearchOptions {
 		out.Filter = AllowAll
 	}
 
+	if out.Epsilon <= 0 {
+		out.Epsilon = 1.1
+	}
+
 	return &out
 }
 
@@ -67,12 +125,32 @@ Searches multiple indices for nearest neighbours in parallel, and combines the r
 */
 func SearchAll(target Point, k int, options *SearchOptions, indices ...SpaceIndex) []PointDistance {
 	all := make([][]PointDistance, len(indices))
-	l := 0
+
+	// each index counts into its own stats, which are added up after
+	var stats []SearchStats
+	if options != nil && options.Stats != nil {
+		stats = make([]SearchStats, len(indices))
+	}
+
 	ForkLoop(len(indices), func(i int) {
-		all[i] = indices[i].NearestNeighbours(target, k, options)
-		l += len(all[i])
+		opt := options
+		if stats != nil {
+			copied := *options
+			copied.Stats = &stats[i]
+			opt = &copied
+		}
+		all[i] = indices[i].NearestNeighbours(target, k, opt)
 	})
 
+	if stats != nil {
+		options.Stats.add(stats)
+	}
+
+	l := 0
+	for _, list := range all {
+		l += len(list)
+	}
+
 	results := make([]PointDistance, 0, l)
 	for _, list := range all {
 		results = append(results, list...)
@@ -144,6 +222,7 @@ func (bf *bruteForceIndex) Write(w io.Writer) (int64, error) {
 
 func (bf *bruteForceIndex) NearestNeighbours(target Point, k int, options *SearchOptions) []PointDistance {
 	opt := getOptions(options)
+	target = prepareQuery(bf.MetricSpace, target)
 	results := make(pointHeap, 0, k)
 	var mutex sync.Mutex
 
@@ -271,6 +350,10 @@ func NewShuffledSpace(space MetricSpace) MetricSpace {
 	return ss
 }
 
+func (ss *shuffledSpace) Length() int {
+	return len(ss.mapping)
+}
+
 func (ss *shuffledSpace) At(index int) Point {
 	return ss.MetricSpace.At(ss.mapping[index])
 }
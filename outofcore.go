@@ -0,0 +1,346 @@
+package nnsearch
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Options for building a graph out of core. All options are optional.
+type OutOfCoreOptions struct {
+	// Number of nearest neighbours kept for each node. Defaults to 50.
+	K int
+
+	// Number of points in each partition, which is built in memory as an
+	// ordinary graph. Consecutive partitions overlap by half. Defaults to
+	// 1000000.
+	PartitionSize int
+
+	// Directory for the partition graphs spilled to disk. Defaults to the
+	// directory for temporary files.
+	TempDir string
+
+	// Number of passes over the merged graph that look for closer neighbours
+	// among the neighbours of neighbours, which finds edges between points
+	// that never shared a partition. Defaults to 2. Graphs cut into many
+	// small partitions need more passes to reach the recall of one built in
+	// memory. Set it to a negative number to skip refinement.
+	Refinements int
+}
+
+func getOutOfCoreOptions(in *OutOfCoreOptions) *OutOfCoreOptions {
+	var out OutOfCoreOptions
+	if in != nil {
+		out = *in
+	}
+
+	if out.K <= 0 {
+		out.K = 50
+	}
+
+	if out.PartitionSize <= 1 {
+		out.PartitionSize = 1000000
+	}
+
+	if out.Refinements == 0 {
+		out.Refinements = 2
+	} else if out.Refinements < 0 {
+		out.Refinements = 0
+	}
+
+	return &out
+}
+
+// BuildGraphOutOfCore builds a graph over a space that is too large for
+// NewGraphIndex, and writes it to filename in the format read by
+// LoadGraphIndex.
+//
+// The points are ordered by the permutation of their closest pivots, so that
+// nearby points tend to be close in the order, and the order is cut into
+// partitions that overlap by half. A graph is built in memory for each
+// partition in turn and spilled to a frozen file. Finally the edges that each
+// point has in the two partitions containing it are merged into the output,
+// one point at a time.
+func BuildGraphOutOfCore(space MetricSpace, filename string, options *OutOfCoreOptions) error {
+	opt := getOutOfCoreOptions(options)
+	n := space.Length()
+	if n == 0 {
+		return fmt.Errorf("cannot build a graph over an empty space")
+	}
+
+	dir, err := ioutil.TempDir(opt.TempDir, "nnsearch")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	order := pivotOrder(space)
+	position := make([]int32, n)
+	for i, u := range order {
+		position[u] = int32(i)
+	}
+
+	size := opt.PartitionSize
+	if size > n {
+		size = n
+	}
+	stride := (size + 1) / 2
+	partitions := 1
+	if n > size {
+		partitions += (n - size + stride - 1) / stride
+	}
+
+	// build and spill the graph of each partition
+	for p := 0; p < partitions; p++ {
+		start := p * stride
+		end := start + size
+		if end > n {
+			end = n
+		}
+
+		log.Printf("Building partition %d of %d", p+1, partitions)
+		err = buildPartition(space, order[start:end], opt.K, partitionFilename(dir, p))
+		if err != nil {
+			return err
+		}
+	}
+
+	files := make([]*FrozenFile, partitions)
+	for p := range files {
+		files[p], err = OpenFrozenFile(partitionFilename(dir, p))
+		if err != nil {
+			return err
+		}
+		defer files[p].Close()
+	}
+
+	// merge the edges of each point from the partitions that contain it
+	log.Printf("Merging %d partitions", partitions)
+	merged := filename
+	if opt.Refinements > 0 {
+		merged = filepath.Join(dir, "merged-0.dat")
+	}
+
+	err = freezeGraphFile(merged, n, func(u int) edgeHeap {
+		pos := int(position[u])
+		var edges edgeHeap
+		for p := pos / stride; p >= 0 && pos < p*stride+size; p-- {
+			if p >= partitions {
+				continue
+			}
+			var part edgeHeap
+			files[p].GetItem(pos-p*stride, &part)
+			edges = append(edges, part...)
+		}
+		return closestEdges(edges, 2*opt.K)
+	}, dir)
+	if err != nil {
+		return err
+	}
+
+	for r := 1; r <= opt.Refinements; r++ {
+		log.Printf("Refinement %d of %d", r, opt.Refinements)
+		next := filename
+		if r < opt.Refinements {
+			next = filepath.Join(dir, fmt.Sprintf("merged-%d.dat", r))
+		}
+
+		err = refineGraphFile(space, merged, next, opt.K, dir)
+		if err != nil {
+			return err
+		}
+		merged = next
+	}
+
+	return nil
+}
+
+// closestEdges returns the k shortest edges to distinct nodes, shortest first.
+func closestEdges(edges []edge, k int) edgeHeap {
+	unique := uniqueEdges(edges)
+	sort.Slice(unique, func(a, b int) bool {
+		return unique[a].distance < unique[b].distance
+	})
+	if len(unique) > k {
+		unique = unique[:k]
+	}
+	return unique
+}
+
+// freezeGraphFile writes the edges of n nodes, produced one at a time, to a
+// file in the format read by LoadGraphIndex.
+func freezeGraphFile(filename string, n int, get func(u int) edgeHeap, dir string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	os.Remove(LayersFilename(filename))
+
+	counter := NewCounter(100000)
+	bw := bufio.NewWriter(file)
+	_, err = FreezeItemsFrom(bw, n, func(u int) FrozenItem {
+		counter.Count()
+		edges := get(u)
+		return &edges
+	}, dir)
+	if err != nil {
+		return err
+	}
+
+	return bw.Flush()
+}
+
+// refineGraphFile reads a graph from a file, compares each node with the
+// closest neighbours of its closest neighbours, and writes the improved graph
+// to another file. Only the file being read is accessed at random.
+func refineGraphFile(space MetricSpace, in, out string, k int, dir string) error {
+	ff, err := OpenFrozenFile(in)
+	if err != nil {
+		return err
+	}
+	defer ff.Close()
+
+	n := int(ff.GetCount())
+	return freezeGraphFile(out, n, func(u int) edgeHeap {
+		var edges edgeHeap
+		ff.GetItem(u, &edges)
+
+		have := make(map[int]bool)
+		have[u] = true
+		for _, e := range edges {
+			have[e.index] = true
+		}
+
+		pt := space.At(u)
+		candidates := append(edgeHeap(nil), edges...)
+		for _, e := range edges[:minInt(k, len(edges))] {
+			var second edgeHeap
+			ff.GetItem(e.index, &second)
+			for _, f := range second[:minInt(k, len(second))] {
+				if !have[f.index] {
+					have[f.index] = true
+					candidates = append(candidates, edge{f.index, space.Distance(pt, space.At(f.index)), false})
+				}
+			}
+		}
+
+		return closestEdges(candidates, 2*k)
+	}, dir)
+}
+
+func partitionFilename(dir string, p int) string {
+	return filepath.Join(dir, fmt.Sprintf("partition-%d.dat", p))
+}
+
+// buildPartition builds a graph over the given points of the space, and
+// writes it with the neighbours numbered by their index in the space.
+func buildPartition(space MetricSpace, nodes []int, k int, filename string) error {
+	g := newGraph(&shuffledSpace{
+		MetricSpace: space,
+		mapping:     nodes,
+	})
+
+	if len(nodes) > 1 {
+		g.gradientDescentKnn(minInt(k, len(nodes)-1))
+	}
+
+	items := make([]FrozenItem, len(nodes))
+	for i := range g.Heaps {
+		for j := range g.Heaps[i] {
+			g.Heaps[i][j].index = nodes[g.Heaps[i][j].index]
+		}
+		items[i] = &g.Heaps[i]
+	}
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	bw := bufio.NewWriter(file)
+	FreezeItems(bw, items)
+	return bw.Flush()
+}
+
+// The number of points sampled to choose the pivots that order a space.
+const pivotSamples = 10000
+
+// pivotOrder returns the points of the space ordered by the permutation of
+// their closest pivots, as initializeUsingPivots orders them. The pivots are
+// chosen from a sample, and only the eight closest pivots of each point are
+// kept, packed into a key, so the memory used stays small.
+func pivotOrder(space MetricSpace) []int {
+	n := space.Length()
+	samples := pivotSamples
+	if samples > n {
+		samples = n
+	}
+
+	sample := &shuffledSpace{
+		MetricSpace: space,
+		mapping:     randomSample(n, samples),
+	}
+
+	np := int(math.Max(math.Log2(float64(n)), 3))
+	if np > 255 {
+		np = 255
+	}
+	log.Printf("Choosing %d pivots", np)
+	pivots := ChooseKPivots(sample, np)
+	points := make([]Point, len(pivots))
+	for i, pivot := range pivots {
+		points[i] = sample.At(pivot.Index)
+	}
+
+	keys := make([]uint64, n)
+	ForkLoop(n, func(u int) {
+		pt := space.At(u)
+		distances := make([]float64, len(points))
+		for i, p := range points {
+			distances[i] = space.Distance(pt, p)
+		}
+
+		closest := Sequence(len(points))
+		sort.Slice(closest, func(a, b int) bool {
+			return distances[closest[a]] < distances[closest[b]]
+		})
+
+		var key uint64
+		for i := 0; i < 8; i++ {
+			key <<= 8
+			if i < len(closest) {
+				key |= uint64(closest[i])
+			}
+		}
+		keys[u] = key
+	})
+
+	order := Sequence(n)
+	sort.Slice(order, func(a, b int) bool {
+		return keys[order[a]] < keys[order[b]]
+	})
+	return order
+}
+
+// randomSample returns k distinct integers chosen at random from [0, n).
+func randomSample(n, k int) []int {
+	have := make(map[int]bool)
+	var results []int
+
+	for len(results) < k {
+		choice := rand.Intn(n)
+		if !have[choice] {
+			have[choice] = true
+			results = append(results, choice)
+		}
+	}
+	return results
+}
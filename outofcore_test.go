@@ -0,0 +1,117 @@
+package nnsearch
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestBuildGraphOutOfCore(t *testing.T) {
+	space := NewVectorSpace(randomVectors(6000, 8), EuclideanDistance)
+	dir, err := ioutil.TempDir("", "outofcoretest")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// partitions of 2000 points overlapping by half cover 6000 points in 5.
+	// Neighbours that never shared a partition are found by refinement.
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	err = BuildGraphOutOfCore(space, "outofcoretest.dat", &OutOfCoreOptions{
+		K:             20,
+		PartitionSize: 2000,
+		TempDir:       dir,
+		Refinements:   4,
+	})
+	log.SetOutput(os.Stderr)
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove("outofcoretest.dat")
+
+	if !strings.Contains(logged.String(), "Building partition 5 of 5") {
+		log.Panicf("Expected 5 partitions, got log:\n%s", logged.String())
+	}
+
+	// the partitions were spilled to the temporary directory and removed
+	left, err := ioutil.ReadDir(dir)
+	if err != nil {
+		panic(err)
+	}
+	if len(left) != 0 {
+		log.Panicf("%d files left in the temporary directory", len(left))
+	}
+
+	g, err := LoadGraphIndex("outofcoretest.dat", space)
+	if err != nil {
+		panic(err)
+	}
+	defer g.(*frozenGraph).Close()
+
+	if n := g.(IGraph).GetNodeCount(); n != space.Length() {
+		log.Panicf("Graph has %d nodes, expected %d", n, space.Length())
+	}
+
+	// the merged partitions find about as many neighbours as a graph built
+	// from a single partition
+	err = BuildGraphOutOfCore(space, "singletest.dat", &OutOfCoreOptions{K: 20, TempDir: dir})
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove("singletest.dat")
+	single, err := LoadGraphIndex("singletest.dat", space)
+	if err != nil {
+		panic(err)
+	}
+	defer single.(*frozenGraph).Close()
+
+	exact := NewBruteForceIndex(space)
+	queries := randomVectors(200, 8)
+	r1 := meanRecall(single, exact, queries, 10, nil)
+	r2 := meanRecall(g, exact, queries, 10, nil)
+	t.Logf("recall %v from one partition, %v from 5", r1, r2)
+	if r2 < 0.9 || r2 < r1-0.05 {
+		log.Panicf("Recall %v from 5 partitions, %v from one", r2, r1)
+	}
+}
+
+func TestBuildPartition(t *testing.T) {
+	space := NewVectorSpace(randomVectors(500, 4), EuclideanDistance)
+	nodes := Sequence(500)[250:]
+	err := buildPartition(space, nodes, 10, "partitiontest.dat")
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove("partitiontest.dat")
+
+	ff, err := OpenFrozenFile("partitiontest.dat")
+	if err != nil {
+		panic(err)
+	}
+	defer ff.Close()
+
+	if ff.GetCount() != int64(len(nodes)) {
+		log.Panicf("Partition has %d nodes, expected %d", ff.GetCount(), len(nodes))
+	}
+
+	// neighbours are numbered by their index in the space
+	for i, u := range nodes {
+		var edges edgeHeap
+		ff.GetItem(i, &edges)
+		if len(edges) < 10 {
+			log.Panicf("Node %d has %d edges, expected at least 10", u, len(edges))
+		}
+		for _, e := range edges {
+			if e.index < 250 || e.index == u {
+				log.Panicf("Node %d has an edge to %d outside of the partition", u, e.index)
+			}
+			if e.distance != space.Distance(space.At(u), space.At(e.index)) {
+				log.Panicf("Edge from %d to %d has the wrong distance", u, e.index)
+			}
+		}
+	}
+}
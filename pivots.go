@@ -86,8 +86,8 @@ func ChooseKPivots(space MetricSpace, k int) Pivots {
 
 func (pivots Pivots) Hash(u int) []int {
 	result := Sequence(len(pivots))
-	sort.Slice(Sequence(len(pivots)), func(a, b int) bool {
-		return pivots[a].Distances[u] < pivots[b].Distances[u]
+	sort.Slice(result, func(a, b int) bool {
+		return pivots[result[a]].Distances[u] < pivots[result[b]].Distances[u]
 	})
 	return result
 }
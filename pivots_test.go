@@ -0,0 +1,52 @@
+package nnsearch
+
+import (
+	"log"
+	"testing"
+)
+
+func meanEdgeDistance(g *graph) float64 {
+	var total float64
+	var count int
+	for _, edges := range g.Heaps {
+		for _, e := range edges {
+			total += e.distance
+			count++
+		}
+	}
+	return total / float64(count)
+}
+
+func TestPivotHash(t *testing.T) {
+	space := NewVectorSpace(randomVectors(2000, 2), EuclideanDistance)
+	pivots := ChooseKPivots(space, 6)
+
+	for u := 0; u < space.Length(); u++ {
+		hash := pivots.Hash(u)
+		seen := make([]bool, len(pivots))
+		for i, p := range hash {
+			if seen[p] {
+				log.Panicf("hash of %d repeats pivot %d: %v", u, p, hash)
+			}
+			seen[p] = true
+
+			if i > 0 && pivots[hash[i-1]].Distances[u] > pivots[p].Distances[u] {
+				log.Panicf("hash of %d is not ordered by distance: %v", u, hash)
+			}
+		}
+	}
+
+	// points next to each other in the order of their hashes are close, so
+	// the initial neighbours are much better than random ones
+	k := 10
+	random := newGraph(space)
+	random.randomize(k)
+	initialized := newGraph(space)
+	initialized.initializeUsingPivots(pivots, k)
+
+	r, p := meanEdgeDistance(random), meanEdgeDistance(initialized)
+	t.Logf("mean edge distance: random %v, pivots %v", r, p)
+	if p > 0.8*r {
+		log.Panicf("pivot initialization %v is no better than random %v", p, r)
+	}
+}
@@ -0,0 +1,367 @@
+package nnsearch
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+
+	"golang.org/x/exp/mmap"
+)
+
+// ProductQuantizer compresses vectors by splitting them into subvectors and
+// replacing each subvector by the index of the closest centroid trained for its
+// subspace, so a vector is stored in one byte per subspace.
+type ProductQuantizer struct {
+	Dims      int
+	Subspaces int
+	Centroids int
+
+	// Codebooks[m] holds the centroids of subspace m, one after the other.
+	Codebooks [][]float32
+}
+
+// TrainProductQuantizer trains a quantizer with the given number of subspaces
+// from a random sample of the vectors in the space.
+func TrainProductQuantizer(space MetricSpace, subspaces, samples int) *ProductQuantizer {
+	n := space.Length()
+	if n == 0 {
+		log.Panic("cannot train a product quantizer on an empty space")
+	}
+
+	if samples > n {
+		samples = n
+	}
+
+	if samples < 1 {
+		log.Panicf("cannot train a product quantizer on %d samples", samples)
+	}
+
+	dims := len(VectorOf(space.At(0)))
+	if subspaces < 1 || subspaces > dims {
+		log.Panicf("cannot split %d dimensions into %d subspaces", dims, subspaces)
+	}
+
+	data := make([][]float32, samples)
+	for i, u := range rand.Perm(n)[:samples] {
+		data[i] = VectorOf(space.At(u))
+	}
+
+	pq := &ProductQuantizer{
+		Dims:      dims,
+		Subspaces: subspaces,
+		Centroids: 256,
+		Codebooks: make([][]float32, subspaces),
+	}
+
+	if pq.Centroids > samples {
+		pq.Centroids = samples
+	}
+
+	log.Printf("Training %d codebooks of %d centroids", subspaces, pq.Centroids)
+	ForkLoop(subspaces, func(m int) {
+		start, end := pq.bounds(m)
+		sub := make([][]float32, samples)
+		for i := range data {
+			sub[i] = data[i][start:end]
+		}
+
+		centroids := kmeans(sub, pq.Centroids, 25)
+		for _, c := range centroids {
+			pq.Codebooks[m] = append(pq.Codebooks[m], c...)
+		}
+	})
+
+	return pq
+}
+
+// bounds returns the range of dimensions covered by subspace m.
+func (pq *ProductQuantizer) bounds(m int) (start, end int) {
+	return m * pq.Dims / pq.Subspaces, (m + 1) * pq.Dims / pq.Subspaces
+}
+
+func (pq *ProductQuantizer) centroid(m, c int) []float32 {
+	start, end := pq.bounds(m)
+	d := end - start
+	return pq.Codebooks[m][c*d : (c+1)*d]
+}
+
+// Quantize returns the code of the vector, one byte per subspace.
+func (pq *ProductQuantizer) Quantize(vec []float32) []byte {
+	code := make([]byte, pq.Subspaces)
+	for m := range code {
+		start, end := pq.bounds(m)
+		best := math.Inf(1)
+		for c := 0; c < pq.Centroids; c++ {
+			d := squaredDistance(vec[start:end], pq.centroid(m, c))
+			if d < best {
+				best = d
+				code[m] = byte(c)
+			}
+		}
+	}
+	return code
+}
+
+// Reconstruct returns the approximate vector represented by a code.
+func (pq *ProductQuantizer) Reconstruct(code []byte) []float32 {
+	vec := make([]float32, 0, pq.Dims)
+	for m, c := range code {
+		vec = append(vec, pq.centroid(m, int(c))...)
+	}
+	return vec
+}
+
+// distanceTable returns the squared distances from each subvector of vec to
+// every centroid of its subspace.
+func (pq *ProductQuantizer) distanceTable(vec []float32) []float32 {
+	table := make([]float32, pq.Subspaces*pq.Centroids)
+	for m := 0; m < pq.Subspaces; m++ {
+		start, end := pq.bounds(m)
+		for c := 0; c < pq.Centroids; c++ {
+			table[m*pq.Centroids+c] = float32(squaredDistance(vec[start:end], pq.centroid(m, c)))
+		}
+	}
+	return table
+}
+
+func (pq *ProductQuantizer) Encode(w io.Writer) uint64 {
+	l := WriteThing(w, pq.Dims)
+	l += WriteThing(w, pq.Subspaces)
+	l += WriteThing(w, pq.Centroids)
+	l += Encode(w, pq.Codebooks)
+	return l
+}
+
+func (pq *ProductQuantizer) Decode(r ByteInputStream) {
+	var count int
+	ReadThing(r, &pq.Dims)
+	ReadThing(r, &pq.Subspaces)
+	ReadThing(r, &pq.Centroids)
+	ReadThing(r, &count)
+	pq.Codebooks = make([][]float32, count)
+	for m := range pq.Codebooks {
+		ReadThing(r, &pq.Codebooks[m])
+	}
+}
+
+// PQCode is a point of a PQSpace.
+type PQCode struct {
+	Index int
+	Code  []byte
+}
+
+type pqQuery struct {
+	vector []float32
+	table  []float32
+}
+
+// PQSpace is a metric space over product quantized vectors. Distances between
+// two codes are computed symmetrically from the centroids, and distances from a
+// query to a code are computed asymmetrically using the query's full vector,
+// through a lookup table built once per query by PrepareQuery. Distances
+// approximate the euclidean distance. Queries that were not prepared build
+// their table on each call.
+type PQSpace struct {
+	pq        *ProductQuantizer
+	codes     []byte
+	symmetric []float32
+}
+
+// NewPQSpace quantizes all vectors of the space.
+func NewPQSpace(pq *ProductQuantizer, space MetricSpace) *PQSpace {
+	n := space.Length()
+	codes := make([]byte, n*pq.Subspaces)
+	ForkLoop(n, func(i int) {
+		copy(codes[i*pq.Subspaces:], pq.Quantize(VectorOf(space.At(i))))
+	})
+
+	return newPQSpace(pq, codes)
+}
+
+func newPQSpace(pq *ProductQuantizer, codes []byte) *PQSpace {
+	k := pq.Centroids
+	symmetric := make([]float32, pq.Subspaces*k*k)
+	ForkLoop(pq.Subspaces, func(m int) {
+		for a := 0; a < k; a++ {
+			for b := 0; b < k; b++ {
+				symmetric[(m*k+a)*k+b] = float32(squaredDistance(pq.centroid(m, a), pq.centroid(m, b)))
+			}
+		}
+	})
+
+	return &PQSpace{
+		pq:        pq,
+		codes:     codes,
+		symmetric: symmetric,
+	}
+}
+
+// Quantizer returns the product quantizer used to encode the space.
+func (s *PQSpace) Quantizer() *ProductQuantizer {
+	return s.pq
+}
+
+func (s *PQSpace) Length() int {
+	return len(s.codes) / s.pq.Subspaces
+}
+
+func (s *PQSpace) At(i int) Point {
+	m := s.pq.Subspaces
+	return &PQCode{
+		Index: i,
+		Code:  s.codes[i*m : (i+1)*m],
+	}
+}
+
+func (s *PQSpace) PrepareQuery(target Point) Point {
+	switch target.(type) {
+	case *pqQuery, *PQCode:
+		return target
+	}
+
+	vec := VectorOf(target)
+	return &pqQuery{
+		vector: vec,
+		table:  s.pq.distanceTable(vec),
+	}
+}
+
+func (s *PQSpace) Distance(p1, p2 Point) float64 {
+	c1, ok1 := p1.(*PQCode)
+	c2, ok2 := p2.(*PQCode)
+	k := s.pq.Centroids
+
+	switch {
+	case ok1 && ok2:
+		var sum float32
+		for m := range c1.Code {
+			sum += s.symmetric[(m*k+int(c1.Code[m]))*k+int(c2.Code[m])]
+		}
+		return math.Sqrt(float64(sum))
+	case ok1 || ok2:
+		if ok2 {
+			c1, p2 = c2, p1
+		}
+		q := s.PrepareQuery(p2).(*pqQuery)
+		var sum float32
+		for m, c := range c1.Code {
+			sum += q.table[m*k+int(c)]
+		}
+		return math.Sqrt(float64(sum))
+	}
+
+	return EuclideanDistance(queryVector(p1), queryVector(p2))
+}
+
+func queryVector(pt Point) []float32 {
+	if q, ok := pt.(*pqQuery); ok {
+		return q.vector
+	}
+	return VectorOf(pt)
+}
+
+// Write saves the quantizer and codes so they can be read with LoadPQSpace.
+func (s *PQSpace) Write(w io.Writer) (int64, error) {
+	l := s.pq.Encode(w)
+	l += WriteThing(w, len(s.codes))
+	n, err := w.Write(s.codes)
+	return int64(l) + int64(n), err
+}
+
+// LoadPQSpace reads a space written by PQSpace.Write.
+func LoadPQSpace(filename string) (*PQSpace, error) {
+	file, err := mmap.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	bs := newByteInputStream(file, 0)
+	pq := &ProductQuantizer{}
+	pq.Decode(bs)
+
+	var count int
+	ReadThing(bs, &count)
+	if bs.pos+count > file.Len() {
+		return nil, fmt.Errorf("%s: truncated codes", filename)
+	}
+
+	codes := make([]byte, count)
+	_, err = file.ReadAt(codes, int64(bs.pos))
+	if err != nil {
+		return nil, err
+	}
+
+	return newPQSpace(pq, codes), nil
+}
+
+func squaredDistance(vec1, vec2 []float32) float64 {
+	var sum float64
+	for i := range vec1 {
+		d := float64(vec1[i] - vec2[i])
+		sum += d * d
+	}
+	return sum
+}
+
+// kmeans clusters the data into k centroids using Lloyd's algorithm, starting
+// from randomly chosen points.
+func kmeans(data [][]float32, k, iterations int) [][]float32 {
+	d := len(data[0])
+	centroids := make([][]float32, k)
+	for i, u := range rand.Perm(len(data))[:k] {
+		centroids[i] = append([]float32(nil), data[u]...)
+	}
+
+	assignment := make([]int, len(data))
+	for iter := 0; iter < iterations; iter++ {
+		changed := 0
+		for i, vec := range data {
+			best := math.Inf(1)
+			bestC := 0
+			for c, centroid := range centroids {
+				dist := squaredDistance(vec, centroid)
+				if dist < best {
+					best = dist
+					bestC = c
+				}
+			}
+			if assignment[i] != bestC || iter == 0 {
+				changed++
+			}
+			assignment[i] = bestC
+		}
+
+		if changed == 0 {
+			break
+		}
+
+		sums := make([][]float64, k)
+		counts := make([]int, k)
+		for c := range sums {
+			sums[c] = make([]float64, d)
+		}
+		for i, vec := range data {
+			c := assignment[i]
+			counts[c]++
+			for j, x := range vec {
+				sums[c][j] += float64(x)
+			}
+		}
+
+		for c := range centroids {
+			if counts[c] == 0 {
+				// reseed empty clusters with a random point
+				copy(centroids[c], data[rand.Intn(len(data))])
+				continue
+			}
+			for j := range centroids[c] {
+				centroids[c][j] = float32(sums[c][j] / float64(counts[c]))
+			}
+		}
+	}
+
+	return centroids
+}
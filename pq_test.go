@@ -0,0 +1,112 @@
+package nnsearch
+
+import (
+	"bufio"
+	"log"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+func randomVectors(n, d int) [][]float32 {
+	vectors := make([][]float32, n)
+	for i := range vectors {
+		vectors[i] = make([]float32, d)
+		for j := range vectors[i] {
+			vectors[i][j] = rand.Float32()
+		}
+	}
+	return vectors
+}
+
+func TestProductQuantizer(t *testing.T) {
+	space := NewVectorSpace(randomVectors(2000, 16), EuclideanDistance)
+	pq := TrainProductQuantizer(space, 4, 1000)
+
+	f, err := os.Create("pqtest.dat")
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove("pqtest.dat")
+	b := bufio.NewWriter(f)
+	_, err = NewPQSpace(pq, space).Write(b)
+	if err != nil {
+		panic(err)
+	}
+	b.Flush()
+	f.Close()
+
+	pqSpace, err := LoadPQSpace("pqtest.dat")
+	if err != nil {
+		panic(err)
+	}
+
+	if pqSpace.Length() != space.Length() {
+		log.Panicf("Loaded %v codes, expected %v", pqSpace.Length(), space.Length())
+	}
+
+	// distances to a query that was not prepared are the same as to a
+	// prepared one
+	target := space.At(0)
+	prepared := pqSpace.PrepareQuery(target)
+	for i := 0; i < pqSpace.Length(); i++ {
+		d1 := pqSpace.Distance(pqSpace.At(i), target)
+		d2 := pqSpace.Distance(prepared, pqSpace.At(i))
+		if d1 != d2 {
+			log.Panicf("Distance to unprepared query %v, prepared %v", d1, d2)
+		}
+	}
+
+	// a query buffer that is reused for another query gets its own distances
+	buf := append([]float32(nil), VectorOf(space.At(0))...)
+	pqSpace.Distance(pqSpace.At(5), buf)
+	copy(buf, VectorOf(space.At(1)))
+	if d1, d2 := pqSpace.Distance(pqSpace.At(5), buf), pqSpace.Distance(pqSpace.At(5), space.At(1)); d1 != d2 {
+		log.Panicf("Distance to reused query buffer %v, expected %v", d1, d2)
+	}
+
+	f, err = os.Create("pqvectors.dat")
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove("pqvectors.dat")
+	b = bufio.NewWriter(f)
+	FreezeVectors(b, space)
+	b.Flush()
+	f.Close()
+
+	full, err := OpenFrozenVectors("pqvectors.dat", EuclideanDistance)
+	if err != nil {
+		panic(err)
+	}
+	defer full.Close()
+
+	index := NewBruteForceIndex(pqSpace)
+	found := 0
+	for i := 0; i < 20; i++ {
+		target := space.At(rand.Intn(space.Length()))
+		candidates := index.NearestNeighbours(pqSpace.PrepareQuery(target), 50, nil)
+		results := Rerank(full, target, candidates, 1)
+		if results[0].Index == target.(*DenseVector).Index {
+			found++
+		}
+	}
+
+	if found < 18 {
+		log.Panicf("Reranked PQ search found only %v of 20 points", found)
+	}
+}
+
+func TestProductQuantizerSubspaces(t *testing.T) {
+	space := NewVectorSpace(randomVectors(100, 8), EuclideanDistance)
+	for _, subspaces := range []int{0, -1, 9} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					log.Panicf("Trained a quantizer with %v subspaces of 8 dimensions", subspaces)
+				}
+			}()
+			TrainProductQuantizer(space, subspaces, 100)
+		}()
+	}
+}
@@ -0,0 +1,208 @@
+package nnsearch
+
+import (
+	"container/heap"
+	"log"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// prune diversifies the neighbour lists of the graph using the robust pruning
+// rule of NSG and Vamana. Candidates are considered closest first, and a
+// candidate c is dropped when a neighbour q already kept satisfies
+// alpha * d(q, c) <= d(u, c), because q covers the direction of c. With alpha
+// of 1 this keeps the edges of the relative neighbourhood graph; larger values
+// keep more long edges. Reverse edges are then added back, nodes left without
+// incoming edges are linked to, and components cut off by pruning are
+// reconnected to the rest of the graph.
+func (g *graph) prune(alpha float64, maxDegree int) {
+	n := g.Length()
+	log.Printf("Pruning edges with alpha %v", alpha)
+
+	pruned := make([]edgeHeap, n)
+	ForkLoop(n, func(u int) {
+		pruned[u] = g.robustPrune(u, g.Heaps[u], alpha, maxDegree)
+	})
+
+	// add the reverse of every kept edge, pruning again where this overflows
+	// the degree of a node.
+	rev := make([][]edge, n)
+	for u := 0; u < n; u++ {
+		for _, e := range pruned[u] {
+			rev[e.index] = append(rev[e.index], edge{u, e.distance, false})
+		}
+	}
+
+	ForkLoop(n, func(u int) {
+		candidates := uniqueEdges(append(append([]edge(nil), pruned[u]...), rev[u]...))
+		if len(candidates) <= maxDegree {
+			g.Heaps[u] = candidates
+		} else {
+			g.Heaps[u] = g.robustPrune(u, candidates, alpha, maxDegree)
+		}
+		heap.Init(&g.Heaps[u])
+	})
+
+	g.linkOrphans()
+	g.reconnect()
+}
+
+// linkOrphans adds an edge to each node that pruning left without incoming
+// edges, from the closest of its neighbours, so that searches can reach it.
+func (g *graph) linkOrphans() {
+	n := g.Length()
+	incoming := make([]bool, n)
+	for u := 0; u < n; u++ {
+		for _, e := range g.Heaps[u] {
+			incoming[e.index] = true
+		}
+	}
+
+	for u := 0; u < n; u++ {
+		if incoming[u] || len(g.Heaps[u]) == 0 {
+			continue
+		}
+
+		closest := g.Heaps[u][0]
+		for _, e := range g.Heaps[u] {
+			if e.distance < closest.distance {
+				closest = e
+			}
+		}
+		heap.Push(&g.Heaps[closest.index], edge{u, closest.distance, false})
+	}
+}
+
+// robustPrune returns at most maxDegree diverse edges out of the candidates.
+func (g *graph) robustPrune(u int, candidates []edge, alpha float64, maxDegree int) edgeHeap {
+	candidates = uniqueEdges(candidates)
+	sort.Slice(candidates, func(a, b int) bool {
+		return candidates[a].distance < candidates[b].distance
+	})
+
+	var kept edgeHeap
+	var keptPoints []Point
+	for _, c := range candidates {
+		if len(kept) == maxDegree {
+			break
+		}
+
+		if c.index == u {
+			continue
+		}
+
+		pt := g.At(c.index)
+		covered := false
+		for _, q := range keptPoints {
+			if alpha*g.Distance(q, pt) <= c.distance {
+				covered = true
+				break
+			}
+		}
+
+		if !covered {
+			kept = append(kept, edge{c.index, c.distance, false})
+			keptPoints = append(keptPoints, pt)
+		}
+	}
+
+	return kept
+}
+
+// uniqueEdges removes edges that lead to the same node from the list.
+func uniqueEdges(edges []edge) edgeHeap {
+	have := make(map[int]bool, len(edges))
+	var result edgeHeap
+	for _, e := range edges {
+		if !have[e.index] {
+			have[e.index] = true
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+// The number of nodes of the largest component that are compared to a node of
+// a disconnected component to find a long range edge joining them.
+const reconnectSamples = 1000
+
+// reconnect finds the weakly connected components of the graph, and joins each
+// of them to the largest one with an edge in both directions to the closest of
+// a sample of its nodes.
+func (g *graph) reconnect() {
+	n := g.Length()
+	if n == 0 {
+		return
+	}
+
+	sets := newDisjointSets(n)
+	for u := 0; u < n; u++ {
+		for _, e := range g.Heaps[u] {
+			sets.union(u, e.index)
+		}
+	}
+
+	members := make(map[int][]int)
+	largest := sets.find(0)
+	for u := 0; u < n; u++ {
+		root := sets.find(u)
+		members[root] = append(members[root], u)
+		if len(members[root]) > len(members[largest]) {
+			largest = root
+		}
+	}
+
+	if len(members) == 1 {
+		return
+	}
+
+	log.Printf("Reconnecting %d components", len(members)-1)
+	core := members[largest]
+	for root, nodes := range members {
+		if root == largest {
+			continue
+		}
+
+		bestU, bestV := -1, -1
+		best := math.Inf(1)
+		for _, u := range nodes[:minInt(len(nodes), 5)] {
+			upt := g.At(u)
+			for i := 0; i < reconnectSamples && i < len(core); i++ {
+				v := core[rand.Intn(len(core))]
+				d := g.Distance(upt, g.At(v))
+				if d < best {
+					best, bestU, bestV = d, u, v
+				}
+			}
+		}
+
+		heap.Push(&g.Heaps[bestU], edge{bestV, best, false})
+		heap.Push(&g.Heaps[bestV], edge{bestU, best, false})
+	}
+}
+
+type disjointSets []int
+
+func newDisjointSets(n int) disjointSets {
+	return disjointSets(Sequence(n))
+}
+
+func (ds disjointSets) find(u int) int {
+	for ds[u] != u {
+		ds[u] = ds[ds[u]]
+		u = ds[u]
+	}
+	return u
+}
+
+func (ds disjointSets) union(u, v int) {
+	ds[ds.find(u)] = ds.find(v)
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,60 @@
+package nnsearch
+
+import (
+	"log"
+	"math/rand"
+	"testing"
+)
+
+func TestPrune(t *testing.T) {
+	space := NewVectorSpace(randomVectors(5000, 4), EuclideanDistance)
+	exact := NewBruteForceIndex(space)
+	queries := randomVectors(100, 4)
+
+	// a pruned graph of a given degree finds more than one that keeps the
+	// nearest neighbours
+	plain := NewGraphIndexWithOptions(space, &GraphOptions{K: 6})
+	pruned := NewGraphIndexWithOptions(space, &GraphOptions{K: 32, Prune: true, MaxDegree: 6})
+
+	edges := 0
+	for u := range pruned.Heaps {
+		edges += len(pruned.Heaps[u])
+	}
+	if edges > 5000*6+50 {
+		log.Panicf("Pruned graph has %d edges, expected at most 6 per node", edges)
+	}
+
+	r1 := meanRecall(plain, exact, queries, 10, nil)
+	r2 := meanRecall(pruned, exact, queries, 10, nil)
+	t.Logf("recall %v with 6 nearest neighbours, %v pruned to a mean degree of %v", r1, r2, float64(edges)/5000)
+	if r2 < r1+0.05 {
+		log.Panicf("Pruned recall %v is no better than %v", r2, r1)
+	}
+
+	report := Analyze(pruned, nil)
+	if report.WeakComponents != 1 || report.Unreachable != 0 {
+		log.Panicf("Pruned graph has %d components, %d unreachable nodes", report.WeakComponents, report.Unreachable)
+	}
+}
+
+func TestPruneReconnects(t *testing.T) {
+	// tight clusters far apart have no edges between them in a graph of the
+	// nearest neighbours
+	vectors := make([][]float32, 600)
+	for i := range vectors {
+		centre := float32(i%3) * 100
+		vectors[i] = []float32{centre + rand.Float32(), centre + rand.Float32()}
+	}
+	space := NewVectorSpace(vectors, EuclideanDistance)
+
+	g := NewGraphIndexWithOptions(space, &GraphOptions{K: 5})
+	if components := Analyze(g, nil).WeakComponents; components < 3 {
+		log.Panicf("Expected at least 3 components before pruning, got %d", components)
+	}
+
+	g.prune(1.2, 5)
+	report := Analyze(g, nil)
+	if report.WeakComponents != 1 || report.Unreachable != 0 {
+		log.Panicf("Pruned graph has %d components, %d unreachable nodes", report.WeakComponents, report.Unreachable)
+	}
+}
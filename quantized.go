@@ -0,0 +1,351 @@
+package nnsearch
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+
+	"golang.org/x/exp/mmap"
+)
+
+// Options for scalar quantization. All options are optional.
+type ScalarQuantizerOptions struct {
+	// Store each dimension as an int8 instead of a uint8.
+	Signed bool
+
+	// Train a scale and offset for each dimension instead of a single one for
+	// all dimensions.
+	PerDimension bool
+
+	// Compare vectors by dot product instead of euclidean distance. The
+	// distance is 1 - dot, which for unit vectors is half the squared euclidean
+	// distance.
+	DotProduct bool
+
+	// Number of vectors sampled to train the scale and offset. Defaults to
+	// 10000.
+	Samples int
+}
+
+// QuantizedVector is a point of a QuantizedVectorSpace.
+type QuantizedVector struct {
+	Index int
+	Code  []byte
+}
+
+type scalarQuery struct {
+	vector []float32
+}
+
+// QuantizedVectorSpace is a metric space that stores each dimension of a
+// vector in a single byte, using a scale and offset trained from the data.
+// Distances between two points are computed directly on the codes. Queries
+// keep their full precision.
+type QuantizedVectorSpace struct {
+	dims     int
+	signed   bool
+	dot      bool
+	scale    []float32
+	offset   []float32
+	codes    []byte
+	original MetricSpace
+}
+
+func getScalarQuantizerOptions(in *ScalarQuantizerOptions) *ScalarQuantizerOptions {
+	var out ScalarQuantizerOptions
+	if in != nil {
+		out = *in
+	}
+
+	if out.Samples <= 0 {
+		out.Samples = 10000
+	}
+
+	return &out
+}
+
+// NewQuantizedVectorSpace trains a scalar quantizer on a sample of the vectors
+// in the space and quantizes all of them. The original space is kept for
+// re-ranking.
+func NewQuantizedVectorSpace(space MetricSpace, options *ScalarQuantizerOptions) *QuantizedVectorSpace {
+	opt := getScalarQuantizerOptions(options)
+	n := space.Length()
+	if n == 0 {
+		log.Panic("cannot train a scalar quantizer on an empty space")
+	}
+
+	samples := opt.Samples
+	if samples > n {
+		samples = n
+	}
+
+	dims := len(VectorOf(space.At(0)))
+	min := make([]float32, dims)
+	max := make([]float32, dims)
+	for i := range min {
+		min[i] = float32(math.Inf(1))
+		max[i] = float32(math.Inf(-1))
+	}
+
+	for _, u := range rand.Perm(n)[:samples] {
+		for j, x := range VectorOf(space.At(u)) {
+			if x < min[j] {
+				min[j] = x
+			}
+			if x > max[j] {
+				max[j] = x
+			}
+		}
+	}
+
+	if !opt.PerDimension {
+		for j := 1; j < dims; j++ {
+			if min[j] < min[0] {
+				min[0] = min[j]
+			}
+			if max[j] > max[0] {
+				max[0] = max[j]
+			}
+		}
+		min = min[:1]
+		max = max[:1]
+	}
+
+	s := &QuantizedVectorSpace{
+		dims:     dims,
+		signed:   opt.Signed,
+		dot:      opt.DotProduct,
+		scale:    make([]float32, len(min)),
+		offset:   make([]float32, len(min)),
+		codes:    make([]byte, n*dims),
+		original: space,
+	}
+
+	for j := range min {
+		if opt.Signed {
+			s.offset[j] = (min[j] + max[j]) / 2
+			s.scale[j] = (max[j] - min[j]) / 254
+		} else {
+			s.offset[j] = min[j]
+			s.scale[j] = (max[j] - min[j]) / 255
+		}
+
+		if s.scale[j] == 0 {
+			s.scale[j] = 1
+		}
+	}
+
+	ForkLoop(n, func(i int) {
+		copy(s.codes[i*dims:], s.Quantize(VectorOf(space.At(i))))
+	})
+
+	return s
+}
+
+func (s *QuantizedVectorSpace) param(j int) (scale, offset float32) {
+	if len(s.scale) == 1 {
+		return s.scale[0], s.offset[0]
+	}
+	return s.scale[j], s.offset[j]
+}
+
+func (s *QuantizedVectorSpace) value(code byte) float32 {
+	if s.signed {
+		return float32(int8(code))
+	}
+	return float32(code)
+}
+
+// Quantize returns the code of a vector, one byte per dimension.
+func (s *QuantizedVectorSpace) Quantize(vec []float32) []byte {
+	code := make([]byte, s.dims)
+	lo, hi := 0.0, 255.0
+	if s.signed {
+		lo, hi = -127, 127
+	}
+
+	for j, x := range vec {
+		scale, offset := s.param(j)
+		q := math.Round(float64((x - offset) / scale))
+		q = math.Max(lo, math.Min(hi, q))
+		if s.signed {
+			code[j] = byte(int8(q))
+		} else {
+			code[j] = byte(q)
+		}
+	}
+	return code
+}
+
+// Reconstruct returns the approximate vector represented by a code.
+func (s *QuantizedVectorSpace) Reconstruct(code []byte) []float32 {
+	vec := make([]float32, len(code))
+	for j, c := range code {
+		scale, offset := s.param(j)
+		vec[j] = offset + scale*s.value(c)
+	}
+	return vec
+}
+
+func (s *QuantizedVectorSpace) Length() int {
+	return len(s.codes) / s.dims
+}
+
+func (s *QuantizedVectorSpace) At(i int) Point {
+	return &QuantizedVector{
+		Index: i,
+		Code:  s.codes[i*s.dims : (i+1)*s.dims],
+	}
+}
+
+func (s *QuantizedVectorSpace) PrepareQuery(target Point) Point {
+	switch target.(type) {
+	case *QuantizedVector, *scalarQuery:
+		return target
+	}
+	return &scalarQuery{VectorOf(target)}
+}
+
+func (s *QuantizedVectorSpace) Distance(p1, p2 Point) float64 {
+	q1, ok1 := p1.(*QuantizedVector)
+	q2, ok2 := p2.(*QuantizedVector)
+
+	switch {
+	case ok1 && ok2:
+		return s.codeDistance(q1.Code, q2.Code)
+	case ok1 || ok2:
+		if ok2 {
+			q1, p2 = q2, p1
+		}
+		return s.queryDistance(s.PrepareQuery(p2).(*scalarQuery).vector, q1.Code)
+	}
+
+	v1 := s.PrepareQuery(p1).(*scalarQuery).vector
+	v2 := s.PrepareQuery(p2).(*scalarQuery).vector
+	if s.dot {
+		return 1 - dot(v1, v2)
+	}
+	return EuclideanDistance(v1, v2)
+}
+
+func (s *QuantizedVectorSpace) codeDistance(c1, c2 []byte) float64 {
+	if s.dot {
+		var sum float64
+		for j := range c1 {
+			scale, offset := s.param(j)
+			sum += float64(offset+scale*s.value(c1[j])) * float64(offset+scale*s.value(c2[j]))
+		}
+		return 1 - sum
+	}
+
+	if len(s.scale) == 1 {
+		// with a global scale the offsets cancel and the sum stays integral
+		var sum int64
+		for j := range c1 {
+			d := int64(s.value(c1[j])) - int64(s.value(c2[j]))
+			sum += d * d
+		}
+		return float64(s.scale[0]) * math.Sqrt(float64(sum))
+	}
+
+	var sum float64
+	for j := range c1 {
+		d := float64(s.scale[j] * (s.value(c1[j]) - s.value(c2[j])))
+		sum += d * d
+	}
+	return math.Sqrt(sum)
+}
+
+func (s *QuantizedVectorSpace) queryDistance(vec []float32, code []byte) float64 {
+	var sum float64
+	for j, c := range code {
+		scale, offset := s.param(j)
+		x := float64(offset + scale*s.value(c))
+		if s.dot {
+			sum += float64(vec[j]) * x
+		} else {
+			d := float64(vec[j]) - x
+			sum += d * d
+		}
+	}
+
+	if s.dot {
+		return 1 - sum
+	}
+	return math.Sqrt(sum)
+}
+
+// Rerank takes the candidates found by searching the quantized space back to
+// the full precision vectors of the original space, and returns the k closest
+// by their true distance.
+func (s *QuantizedVectorSpace) Rerank(target Point, results []PointDistance, k int) []PointDistance {
+	if s.original == nil {
+		log.Panic("quantized space has no original space to rerank against")
+	}
+	return Rerank(s.original, target, results, k)
+}
+
+// Write saves the scale, offset and codes so they can be read with
+// LoadQuantizedVectorSpace.
+func (s *QuantizedVectorSpace) Write(w io.Writer) (int64, error) {
+	flags := 0
+	if s.signed {
+		flags |= 1
+	}
+	if s.dot {
+		flags |= 2
+	}
+
+	l := WriteThing(w, flags)
+	l += WriteThing(w, s.dims)
+	l += WriteThing(w, s.scale)
+	l += WriteThing(w, s.offset)
+	l += WriteThing(w, len(s.codes))
+	n, err := w.Write(s.codes)
+	return int64(l) + int64(n), err
+}
+
+// LoadQuantizedVectorSpace reads a space written by
+// QuantizedVectorSpace.Write. The original space is used for re-ranking and may
+// be nil.
+func LoadQuantizedVectorSpace(filename string, original MetricSpace) (*QuantizedVectorSpace, error) {
+	file, err := mmap.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	bs := newByteInputStream(file, 0)
+	s := &QuantizedVectorSpace{original: original}
+
+	var flags, count int
+	ReadThing(bs, &flags)
+	ReadThing(bs, &s.dims)
+	ReadThing(bs, &s.scale)
+	ReadThing(bs, &s.offset)
+	ReadThing(bs, &count)
+	s.signed = flags&1 != 0
+	s.dot = flags&2 != 0
+
+	if bs.pos+count > file.Len() {
+		return nil, fmt.Errorf("%s: truncated codes", filename)
+	}
+
+	s.codes = make([]byte, count)
+	_, err = file.ReadAt(s.codes, int64(bs.pos))
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func dot(vec1, vec2 []float32) float64 {
+	var sum float64
+	for i := range vec1 {
+		sum += float64(vec1[i]) * float64(vec2[i])
+	}
+	return sum
+}
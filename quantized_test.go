@@ -0,0 +1,126 @@
+package nnsearch
+
+import (
+	"bufio"
+	"log"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+// recall returns the fraction of the true nearest neighbours that were found.
+func recall(truth, results []PointDistance) float64 {
+	want := make(map[int]bool)
+	for _, pd := range truth {
+		want[pd.Index] = true
+	}
+
+	found := 0
+	for _, pd := range results {
+		if want[pd.Index] {
+			found++
+		}
+	}
+	return float64(found) / float64(len(truth))
+}
+
+// meanRecall searches for each of the queries in the index and in the exact
+// index, and returns the mean recall of the first k results.
+func meanRecall(index, exact SpaceIndex, queries [][]float32, k int, options *SearchOptions) float64 {
+	var total float64
+	for _, q := range queries {
+		truth := exact.NearestNeighbours(&DenseVector{-1, q}, k, nil)
+		total += recall(truth, index.NearestNeighbours(&DenseVector{-1, q}, k, options))
+	}
+	return total / float64(len(queries))
+}
+
+func unitVectors(n, d int) [][]float32 {
+	vectors := randomVectors(n, d)
+	for i := range vectors {
+		for j := range vectors[i] {
+			vectors[i][j] -= 0.5
+		}
+		vectors[i] = normalized(vectors[i])
+	}
+	return vectors
+}
+
+func TestQuantizedVectorSpace(t *testing.T) {
+	dotDistance := func(vec1, vec2 []float32) float64 {
+		return 1 - dot(vec1, vec2)
+	}
+
+	for _, test := range []struct {
+		name     string
+		vectors  [][]float32
+		distance VectorDistance
+		options  ScalarQuantizerOptions
+	}{
+		{"l2", randomVectors(2000, 16), EuclideanDistance, ScalarQuantizerOptions{}},
+		{"l2 signed per dimension", randomVectors(2000, 16), EuclideanDistance, ScalarQuantizerOptions{Signed: true, PerDimension: true}},
+		{"dot", unitVectors(2000, 16), dotDistance, ScalarQuantizerOptions{DotProduct: true}},
+		{"dot signed", unitVectors(2000, 16), dotDistance, ScalarQuantizerOptions{DotProduct: true, Signed: true}},
+	} {
+		space := NewVectorSpace(test.vectors, test.distance)
+		quantized := NewQuantizedVectorSpace(space, &test.options)
+		exact := NewBruteForceIndex(space)
+		index := NewBruteForceIndex(quantized)
+
+		queries := unitVectors(50, 16)
+		if !test.options.DotProduct {
+			queries = randomVectors(50, 16)
+		}
+
+		r := meanRecall(index, exact, queries, 10, nil)
+		t.Logf("%s: recall %v", test.name, r)
+		if r < 0.9 {
+			log.Panicf("%s: quantized recall %v", test.name, r)
+		}
+
+		// re-ranking more candidates by their full vectors finds the rest
+		var reranked float64
+		for _, q := range queries {
+			target := &DenseVector{-1, q}
+			candidates := index.NearestNeighbours(target, 50, nil)
+			results := quantized.Rerank(target, candidates, 10)
+			reranked += recall(exact.NearestNeighbours(target, 10, nil), results)
+		}
+		reranked /= float64(len(queries))
+		t.Logf("%s: reranked recall %v", test.name, reranked)
+		if reranked < 0.99 {
+			log.Panicf("%s: reranked recall %v", test.name, reranked)
+		}
+	}
+}
+
+func TestQuantizedVectorSpaceReadWrite(t *testing.T) {
+	space := NewVectorSpace(randomVectors(500, 8), EuclideanDistance)
+	quantized := NewQuantizedVectorSpace(space, &ScalarQuantizerOptions{Signed: true, PerDimension: true})
+
+	f, err := os.Create("quantizedtest.dat")
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove("quantizedtest.dat")
+	b := bufio.NewWriter(f)
+	if _, err = quantized.Write(b); err != nil {
+		panic(err)
+	}
+	b.Flush()
+	f.Close()
+
+	loaded, err := LoadQuantizedVectorSpace("quantizedtest.dat", space)
+	if err != nil {
+		panic(err)
+	}
+
+	for i := 0; i < 100; i++ {
+		a, b := rand.Intn(space.Length()), rand.Intn(space.Length())
+		d1 := quantized.Distance(quantized.At(a), quantized.At(b))
+		d2 := loaded.Distance(loaded.At(a), loaded.At(b))
+		if d1 != d2 {
+			log.Panicf("Distance %v after loading, %v before", d2, d1)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package nnsearch
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Weighting is a way of weighting the words of a query.
+type Weighting int
+
+const (
+	// Every distinct word counts the same.
+	Unweighted Weighting = iota
+
+	// Words are weighted by their inverse document frequency, estimated as
+	// the log of the inverse of their probability.
+	IDF
+
+	// Smooth inverse frequency: words are weighted by a/(a + p(w)), where
+	// p(w) is their probability.
+	SIF
+)
+
+type QueryOptions struct {
+	// Splits queries into words. Defaults to a UnicodeTokenizer.
+	Tokenizer Tokenizer
+
+	Weighting Weighting
+
+	// The a of SIF weights. Defaults to 1e-3.
+	SIFParameter float64
+
+	// The counts of the words, such as those read by ReadWordCounts. Defaults
+	// to estimating them from the order of the vocabulary, which word2vec,
+	// GloVe and fastText write from the most to the least frequent, assuming
+	// Zipf's law.
+	Counts map[string]float64
+}
+
+func getQueryOptions(in *QueryOptions) *QueryOptions {
+	var out QueryOptions
+	if in != nil {
+		out = *in
+	}
+
+	if out.Tokenizer == nil {
+		out.Tokenizer = &UnicodeTokenizer{}
+	}
+
+	if out.SIFParameter == 0 {
+		out.SIFParameter = 1e-3
+	}
+	return &out
+}
+
+// QueryEncoder makes query vectors from text, as the weighted average of the
+// vectors of its words.
+type QueryEncoder struct {
+	wv      *WordVecs
+	options *QueryOptions
+
+	// probabilities of the known words, and that given to the others
+	probability map[string]float64
+	unseen      float64
+
+	// the common component removed from the vectors, or nil
+	common []float32
+}
+
+// NewQueryEncoder returns an encoder of queries into the space of the
+// vectors.
+func NewQueryEncoder(wv *WordVecs, options *QueryOptions) *QueryEncoder {
+	e := &QueryEncoder{
+		wv:      wv,
+		options: getQueryOptions(options),
+	}
+
+	if e.options.Weighting == Unweighted {
+		return e
+	}
+
+	e.probability = make(map[string]float64)
+	if e.options.Counts != nil {
+		var total float64
+		for _, count := range e.options.Counts {
+			total += count
+		}
+		for word, count := range e.options.Counts {
+			e.probability[word] = count / total
+		}
+		e.unseen = 1 / (total + 1)
+	} else {
+		// the probability of the word of rank r is 1/(r H), where H is the
+		// harmonic number of the size of the vocabulary
+		var harmonic float64
+		for r := 1; r <= len(wv.all); r++ {
+			harmonic += 1 / float64(r)
+		}
+		for i, word := range wv.all {
+			e.probability[word] = 1 / (float64(i+1) * harmonic)
+		}
+		e.unseen = 1 / (float64(len(wv.all)+1) * harmonic)
+	}
+	return e
+}
+
+// Tokenize splits text into words with the tokenizer of the encoder.
+func (e *QueryEncoder) Tokenize(text string) []string {
+	return e.options.Tokenizer.Tokenize(text)
+}
+
+// Weight returns the weight of a word in a query.
+func (e *QueryEncoder) Weight(word string) float64 {
+	p, ok := e.probability[word]
+	if !ok {
+		p = e.unseen
+	}
+
+	switch e.options.Weighting {
+	case IDF:
+		return -math.Log(p)
+	case SIF:
+		return e.options.SIFParameter / (e.options.SIFParameter + p)
+	}
+	return 1
+}
+
+// weightedSum returns the weighted average of the vectors of the words of the
+// text. Unweighted queries sum the vectors of the distinct words instead.
+func (e *QueryEncoder) weightedSum(text string) []float32 {
+	words := e.Tokenize(text)
+	if e.options.Weighting == Unweighted {
+		words = RemoveDuplicateStrings(words)
+	}
+
+	total := make([]float32, e.wv.d)
+	var found int
+	for _, word := range words {
+		vec := e.wv.Get(word)
+		if vec == nil {
+			continue
+		}
+
+		weight := float32(e.Weight(word))
+		for i := range vec {
+			total[i] += weight * vec[i]
+		}
+		found++
+	}
+
+	if e.options.Weighting != Unweighted && found > 0 {
+		for i := range total {
+			total[i] /= float32(found)
+		}
+	}
+	return total
+}
+
+// Encode returns the vector of a query.
+func (e *QueryEncoder) Encode(text string) *WordVector {
+	vec := e.weightedSum(text)
+	if e.common != nil {
+		var dot float32
+		for i := range vec {
+			dot += vec[i] * e.common[i]
+		}
+		for i := range vec {
+			vec[i] -= dot * e.common[i]
+		}
+	}
+
+	return &WordVector{
+		Word:   text,
+		Vector: vec,
+	}
+}
+
+// RemoveCommonComponent makes the encoder subtract from each query vector its
+// projection on the first principal component of the vectors of a sample of
+// texts, as SIF does. The component is found by power iteration.
+func (e *QueryEncoder) RemoveCommonComponent(texts []string) {
+	e.common = nil
+	vectors := make([][]float32, len(texts))
+	ForkLoop(len(texts), func(i int) {
+		vectors[i] = e.weightedSum(texts[i])
+	})
+
+	component := make([]float64, e.wv.d)
+	for i := range component {
+		component[i] = 1 / math.Sqrt(float64(len(component)))
+	}
+
+	for iteration := 0; iteration < 100; iteration++ {
+		// multiply by the covariance X^T X without forming it
+		next := make([]float64, len(component))
+		for _, vec := range vectors {
+			var dot float64
+			for i := range vec {
+				dot += float64(vec[i]) * component[i]
+			}
+			for i := range vec {
+				next[i] += dot * float64(vec[i])
+			}
+		}
+
+		var length, change float64
+		for _, x := range next {
+			length += x * x
+		}
+		length = math.Sqrt(length)
+		if length == 0 {
+			return
+		}
+		for i := range next {
+			next[i] /= length
+			change += math.Abs(next[i] - component[i])
+		}
+
+		component = next
+		if change < 1e-6 {
+			break
+		}
+	}
+
+	e.common = make([]float32, len(component))
+	for i, x := range component {
+		e.common[i] = float32(x)
+	}
+}
+
+// ReadWordCounts reads the counts of words from lines of a word and its count,
+// in either order, as in the vocabulary files written by GloVe and
+// word2vec.
+func ReadWordCounts(r io.Reader) (map[string]float64, error) {
+	counts := make(map[string]float64)
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected a word and its count, got %d fields", lineNumber, len(fields))
+		}
+
+		word, number := fields[0], fields[1]
+		count, err := strconv.ParseFloat(number, 64)
+		if err != nil {
+			// the count comes first
+			word, number = number, word
+			count, err = strconv.ParseFloat(number, 64)
+		}
+
+		if err != nil || count < 0 {
+			return nil, fmt.Errorf("line %d: no count in %q", lineNumber, scanner.Text())
+		}
+		counts[word] += count
+	}
+	return counts, scanner.Err()
+}
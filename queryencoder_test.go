@@ -0,0 +1,59 @@
+package nnsearch
+
+import (
+	"log"
+	"math"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestQueryEncoder(t *testing.T) {
+	tokens := NewUnicodeTokenizer(EnglishStopwords).Tokenize("The  café's\tDON'T e-mail, -- naïve 東京!")
+	if strings.Join(tokens, "|") != "café's|don't|e-mail|naïve|東京" {
+		log.Panicf("Tokenized into %q", tokens)
+	}
+
+	f, err := os.Create("encodertest.bin")
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove("encodertest.bin")
+	err = WriteWordVecs(f, []string{"the", "cat", "sat"}, [][]float32{{1, 0}, {0, 1}, {0, 2}})
+	f.Close()
+	if err != nil {
+		panic(err)
+	}
+
+	wv := OpenWordVecs("encodertest.bin")
+	defer wv.Close()
+	if vec := wv.PointFromQuery("The cat, the cat.").Vector; vec[0] != 1 || vec[1] != 1 {
+		log.Panicf("Unweighted query gave %v", vec)
+	}
+
+	counts, err := ReadWordCounts(strings.NewReader("the 1000\n10 cat\n\nsat 10\n"))
+	if err != nil {
+		panic(err)
+	}
+	if _, err = ReadWordCounts(strings.NewReader("the 1000\ncat\n")); err == nil || !strings.Contains(err.Error(), "line 2") {
+		log.Panicf("Expected an error on line 2, got %v", err)
+	}
+
+	// the frequent word barely counts with SIF
+	e := NewQueryEncoder(wv, &QueryOptions{Weighting: SIF, Counts: counts})
+	wv.SetQueryEncoder(e)
+	if vec := wv.PointFromQuery("the cat").Vector; vec[0] > vec[1]/50 {
+		log.Panicf("SIF query gave %v", vec)
+	}
+
+	// without counts, the words are ranked by their order in the file
+	e = NewQueryEncoder(wv, &QueryOptions{Weighting: IDF})
+	if e.Weight("the") >= e.Weight("sat") || e.Weight("sat") >= e.Weight("unknown") {
+		log.Panicf("IDF weights are not ordered by rank")
+	}
+
+	e.RemoveCommonComponent([]string{"cat", "sat", "cat sat"})
+	if vec := e.Encode("cat").Vector; math.Abs(float64(vec[1])) > 1e-4 {
+		log.Panicf("Common component was not removed: %v", vec)
+	}
+}
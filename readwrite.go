@@ -63,6 +63,29 @@ func WriteThing(w io.Writer, thing interface{}) uint64 {
 	return 0
 }
 
+// Encode writes a value, a FrozenItem, or a slice of either, preceded by its
+// length. It returns the number of bytes written. If w is nil, it only computes
+// the size.
+func Encode(w io.Writer, thing interface{}) uint64 {
+	switch v := thing.(type) {
+	case FrozenItem:
+		return v.Encode(w)
+	case []float32, *[]float32:
+		return WriteThing(w, v)
+	}
+
+	value := reflect.ValueOf(thing)
+	if value.Kind() == reflect.Slice {
+		l := WriteThing(w, value.Len())
+		for i := 0; i < value.Len(); i++ {
+			l += Encode(w, value.Index(i).Interface())
+		}
+		return l
+	}
+
+	return WriteThing(w, thing)
+}
+
 func ReadThing(bs ByteInputStream, thing interface{}) uint64 {
 	switch v := thing.(type) {
 	case *uint64:
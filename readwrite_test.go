@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"testing"
@@ -83,3 +84,145 @@ func TestReadWrite(t *testing.T) {
 	Encode(&buff, slice)
 	log.Printf("Encoded to %v", buff.Bytes())
 }
+
+func TestFreezeItemsFrom(t *testing.T) {
+	var written testStruct
+	written.Hello = "streamed"
+	written.Value2 = []float32{4.0}
+
+	f, err := os.Create("freezetest.dat")
+	if err != nil {
+		panic(err)
+	}
+
+	b := bufio.NewWriter(f)
+	_, err = FreezeItemsFrom(b, 3, func(i int) FrozenItem {
+		written.Value3 = int64(i)
+		return &written
+	}, "")
+	if err != nil {
+		panic(err)
+	}
+	b.Flush()
+	f.Close()
+
+	ff, _ := OpenFrozenFile("freezetest.dat")
+	defer ff.Close()
+
+	if ff.GetCount() != 3 {
+		log.Panicf("getCount returned %v", ff.GetCount())
+	}
+
+	var read testStruct
+	ff.GetItem(2, &read)
+	str := (&read).String()
+	if str != "{streamed 0 [4] 2}" {
+		log.Panicf("Read incorrect value, got %v", str)
+	}
+}
+
+func TestWideFrozenFile(t *testing.T) {
+	// files over 4GB mark their count and store 8 byte offsets
+	items := make([]FrozenItem, 3)
+	for i := range items {
+		items[i] = &testStruct{Hello: "wide", Value2: []float32{float32(i)}, Value3: int64(i)}
+	}
+
+	var buff bytes.Buffer
+	count := uint64(len(items)) | wideOffsets
+	off := WriteThing(&buff, count) + 8*uint64(len(items))
+	for _, item := range items {
+		writeOffset(&buff, off, 8)
+		off += item.Encode(nil)
+	}
+	for _, item := range items {
+		item.Encode(&buff)
+	}
+
+	err := ioutil.WriteFile("widetest.dat", buff.Bytes(), 0644)
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove("widetest.dat")
+
+	ff, err := OpenFrozenFile("widetest.dat")
+	if err != nil {
+		panic(err)
+	}
+	defer ff.Close()
+
+	if ff.GetCount() != 3 || ff.OffsetSize() != 8 {
+		log.Panicf("Read %d items with %d byte offsets", ff.GetCount(), ff.OffsetSize())
+	}
+
+	for i := range items {
+		var read testStruct
+		ff.GetItem(i, &read)
+		if read.String() != items[i].(*testStruct).String() {
+			log.Panicf("Read %v, expected %v", read.String(), items[i])
+		}
+	}
+}
+
+func TestVecsFiles(t *testing.T) {
+	vectors := [][]float32{{1, 2.5, 3}, {4, 5, 300}}
+
+	var buf bytes.Buffer
+	err := WriteFvecs(&buf, NewVectorSpace(vectors, EuclideanDistance))
+	if err != nil {
+		panic(err)
+	}
+	err = ioutil.WriteFile("vecstest.fvecs", buf.Bytes(), 0644)
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove("vecstest.fvecs")
+
+	buf.Reset()
+	err = WriteBvecs(&buf, NewVectorSpace(vectors, EuclideanDistance))
+	if err != nil {
+		panic(err)
+	}
+	err = ioutil.WriteFile("vecstest.bvecs", buf.Bytes(), 0644)
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove("vecstest.bvecs")
+
+	fv, err := OpenFvecs("vecstest.fvecs", EuclideanDistance)
+	if err != nil {
+		panic(err)
+	}
+	defer fv.Close()
+	if fv.Length() != 2 || fv.Dims() != 3 || VectorOf(fv.At(1))[2] != 300 {
+		log.Panicf("Read wrong vectors from fvecs file: %v", VectorOf(fv.At(1)))
+	}
+
+	bv, err := OpenBvecs("vecstest.bvecs", EuclideanDistance)
+	if err != nil {
+		panic(err)
+	}
+	defer bv.Close()
+	if got := VectorOf(bv.At(0)); got[1] != 3 || VectorOf(bv.At(1))[2] != 255 {
+		log.Panicf("Read wrong vectors from bvecs file: %v", got)
+	}
+
+	buf.Reset()
+	err = WriteIvecs(&buf, [][]int{{1, 2, 3}, {}, {7}})
+	if err != nil {
+		panic(err)
+	}
+	err = ioutil.WriteFile("vecstest.ivecs", buf.Bytes(), 0644)
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove("vecstest.ivecs")
+
+	lists, err := ReadIvecs("vecstest.ivecs")
+	if err != nil {
+		panic(err)
+	}
+	if len(lists) != 3 || len(lists[1]) != 0 || lists[2][0] != 7 {
+		log.Panicf("Read wrong lists from ivecs file: %v", lists)
+	}
+}
@@ -0,0 +1,236 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/smhanov/nnsearch"
+	"google.golang.org/grpc"
+)
+
+// Client searches an index served by a remote Server. It implements
+// nnsearch.SpaceIndex, so remote shards can be passed to nnsearch.SearchAll
+// together with local ones. Its points are *nnsearch.DenseVector, compared
+// using the distance function given to the client.
+//
+// SpaceIndex has no way of reporting errors, so NearestNeighbours logs a
+// failed call and returns no results, which lets a fan-out over several shards
+// carry on without the failed one. Likewise At logs a failed call and returns
+// nil; use Point to get the error.
+type Client struct {
+	conn     *grpc.ClientConn
+	client   SearchClient
+	distance nnsearch.VectorDistance
+	length   int
+}
+
+// Dial connects to a server, and returns a client that closes the connection
+// when it is closed.
+func Dial(target string, distance nnsearch.VectorDistance, opts ...grpc.DialOption) (*Client, error) {
+	conn, err := grpc.Dial(target, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := NewClient(conn, distance)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	c.conn = conn
+	return c, nil
+}
+
+// NewClient returns a client that searches over an existing connection.
+func NewClient(conn *grpc.ClientConn, distance nnsearch.VectorDistance) (*Client, error) {
+	c := &Client{
+		client:   NewSearchClient(conn),
+		distance: distance,
+	}
+
+	stats, err := c.Stats(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	c.length = int(stats.Points)
+	return c, nil
+}
+
+// Stats returns a description of the remote index and the searches made on it.
+func (c *Client) Stats(ctx context.Context) (*StatsResponse, error) {
+	return c.client.Stats(ctx, &StatsRequest{})
+}
+
+func (c *Client) Length() int {
+	return c.length
+}
+
+// At fetches a point from the server. If the call fails, it is logged and
+// the result is nil.
+func (c *Client) At(i int) nnsearch.Point {
+	pt, err := c.Point(context.Background(), i)
+	if err != nil {
+		log.Printf("Remote fetch of point %d failed: %v", i, err)
+		return nil
+	}
+	return pt
+}
+
+// Point fetches a point from the server.
+func (c *Client) Point(ctx context.Context, i int) (nnsearch.Point, error) {
+	resp, err := c.client.GetNeighbours(ctx, &GetNeighboursRequest{
+		Id:             int64(i),
+		IncludeVectors: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Point == nil {
+		return nil, fmt.Errorf("server returned no point %d", i)
+	}
+
+	return point(resp.Point), nil
+}
+
+func (c *Client) Distance(p1, p2 nnsearch.Point) float64 {
+	return c.distance(nnsearch.VectorOf(p1), nnsearch.VectorOf(p2))
+}
+
+func point(n *Neighbour) nnsearch.Point {
+	return &nnsearch.DenseVector{
+		Index:  int(n.Index),
+		Vector: n.Vector,
+	}
+}
+
+// results converts a response into search results, applying the filter of the
+// options, which cannot be sent to the server. The stats are updated without
+// locking, which is safe when searching through nnsearch.SearchAll, as it
+// gives each index its own stats.
+func results(resp *SearchResponse, opt *nnsearch.SearchOptions) []nnsearch.PointDistance {
+	var results []nnsearch.PointDistance
+	for _, n := range resp.Results {
+		pt := point(n)
+		if opt.Filter != nil && !opt.Filter(pt) {
+			continue
+		}
+
+		results = append(results, nnsearch.PointDistance{
+			Index:    int(n.Index),
+			Point:    pt,
+			Distance: n.Distance,
+		})
+	}
+
+	if opt.Stats != nil {
+		opt.Stats.Visited += int(resp.Visited)
+	}
+	return results
+}
+
+func getOptions(in *nnsearch.SearchOptions) *nnsearch.SearchOptions {
+	var out nnsearch.SearchOptions
+	if in != nil {
+		out = *in
+	}
+
+	if out.Ctx == nil {
+		out.Ctx = context.Background()
+	}
+	return &out
+}
+
+func (c *Client) request(target nnsearch.Point, k int, opt *nnsearch.SearchOptions) *SearchRequest {
+	return &SearchRequest{
+		Vector:         nnsearch.VectorOf(target),
+		K:              int32(k),
+		Budget:         int32(opt.Budget),
+		Epsilon:        opt.Epsilon,
+		IncludeVectors: true,
+	}
+}
+
+// NearestNeighbours searches the remote index. The context of the options
+// bounds the call. A filter is applied to the results returned by the server,
+// so fewer than k results may remain.
+func (c *Client) NearestNeighbours(target nnsearch.Point, k int, options *nnsearch.SearchOptions) []nnsearch.PointDistance {
+	opt := getOptions(options)
+	resp, err := c.client.Search(opt.Ctx, c.request(target, k, opt))
+	if err != nil {
+		log.Printf("Remote search failed: %v", err)
+		return nil
+	}
+
+	return results(resp, opt)
+}
+
+// BatchNearestNeighbours searches the remote index for the nearest neighbours
+// of several targets in one call.
+func (c *Client) BatchNearestNeighbours(targets []nnsearch.Point, k int, options *nnsearch.SearchOptions) ([][]nnsearch.PointDistance, error) {
+	opt := getOptions(options)
+	req := &BatchSearchRequest{}
+	for _, target := range targets {
+		req.Queries = append(req.Queries, c.request(target, k, opt))
+	}
+
+	resp, err := c.client.BatchSearch(opt.Ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	all := make([][]nnsearch.PointDistance, len(resp.Responses))
+	for i, r := range resp.Responses {
+		all[i] = results(r, opt)
+	}
+	return all, nil
+}
+
+// RangeSearch finds the points of the remote index within radius of the
+// target.
+func (c *Client) RangeSearch(target nnsearch.Point, radius float64, options *nnsearch.SearchOptions) ([]nnsearch.PointDistance, error) {
+	opt := getOptions(options)
+	resp, err := c.client.RangeSearch(opt.Ctx, &RangeSearchRequest{
+		Vector:         nnsearch.VectorOf(target),
+		Radius:         radius,
+		Budget:         int32(opt.Budget),
+		Epsilon:        opt.Epsilon,
+		IncludeVectors: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return results(resp, opt), nil
+}
+
+// Neighbours finds the k nearest neighbours of a point of the remote index,
+// leaving out the point itself.
+func (c *Client) Neighbours(id int, k int, options *nnsearch.SearchOptions) ([]nnsearch.PointDistance, error) {
+	opt := getOptions(options)
+	resp, err := c.client.GetNeighbours(opt.Ctx, &GetNeighboursRequest{
+		Id:             int64(id),
+		K:              int32(k),
+		Budget:         int32(opt.Budget),
+		Epsilon:        opt.Epsilon,
+		IncludeVectors: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return results(resp, opt), nil
+}
+
+func (c *Client) Write(w io.Writer) (int64, error) {
+	return 0, fmt.Errorf("cannot write remote index")
+}
+
+// Close closes the connection if the client was created by Dial.
+func (c *Client) Close() error {
+	if c.conn != nil {
+		return c.conn.Close()
+	}
+	return nil
+}
@@ -0,0 +1,361 @@
+package rpc
+
+import "fmt"
+
+// The messages of nnsearch.proto. Each implements proto.Message along with
+// Marshal and Unmarshal, which the gRPC protocol buffer codec uses directly.
+// They are written by hand; nnsearch.proto says how to keep them and the
+// messages in wire_test.go in step with it.
+
+type SearchRequest struct {
+	Vector         []float32
+	K              int32
+	Budget         int32
+	Epsilon        float64
+	IncludeVectors bool
+}
+
+func (m *SearchRequest) Reset()         { *m = SearchRequest{} }
+func (m *SearchRequest) String() string { return fmt.Sprintf("%+v", *m) }
+func (*SearchRequest) ProtoMessage()    {}
+
+func (m *SearchRequest) Marshal() ([]byte, error) {
+	var e encoder
+	e.floats(1, m.Vector)
+	e.int64(2, int64(m.K))
+	e.int64(3, int64(m.Budget))
+	e.double(4, m.Epsilon)
+	e.bool(5, m.IncludeVectors)
+	return e.buf, nil
+}
+
+func (m *SearchRequest) Unmarshal(data []byte) error {
+	d := decoder{buf: data}
+	for {
+		ok, err := d.next()
+		if !ok {
+			return err
+		}
+
+		switch d.field {
+		case 1:
+			m.Vector, err = d.floats(m.Vector)
+		case 2:
+			m.K = int32(d.varint)
+		case 3:
+			m.Budget = int32(d.varint)
+		case 4:
+			m.Epsilon = d.double()
+		case 5:
+			m.IncludeVectors = d.varint != 0
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+type BatchSearchRequest struct {
+	Queries []*SearchRequest
+}
+
+func (m *BatchSearchRequest) Reset()         { *m = BatchSearchRequest{} }
+func (m *BatchSearchRequest) String() string { return fmt.Sprintf("%+v", *m) }
+func (*BatchSearchRequest) ProtoMessage()    {}
+
+func (m *BatchSearchRequest) Marshal() ([]byte, error) {
+	var e encoder
+	for _, q := range m.Queries {
+		if err := e.message(1, q); err != nil {
+			return nil, err
+		}
+	}
+	return e.buf, nil
+}
+
+func (m *BatchSearchRequest) Unmarshal(data []byte) error {
+	d := decoder{buf: data}
+	for {
+		ok, err := d.next()
+		if !ok {
+			return err
+		}
+
+		if d.field == 1 {
+			q := &SearchRequest{}
+			if err := q.Unmarshal(d.bytes); err != nil {
+				return err
+			}
+			m.Queries = append(m.Queries, q)
+		}
+	}
+}
+
+type RangeSearchRequest struct {
+	Vector         []float32
+	Radius         float64
+	Budget         int32
+	Epsilon        float64
+	IncludeVectors bool
+}
+
+func (m *RangeSearchRequest) Reset()         { *m = RangeSearchRequest{} }
+func (m *RangeSearchRequest) String() string { return fmt.Sprintf("%+v", *m) }
+func (*RangeSearchRequest) ProtoMessage()    {}
+
+func (m *RangeSearchRequest) Marshal() ([]byte, error) {
+	var e encoder
+	e.floats(1, m.Vector)
+	e.double(2, m.Radius)
+	e.int64(3, int64(m.Budget))
+	e.double(4, m.Epsilon)
+	e.bool(5, m.IncludeVectors)
+	return e.buf, nil
+}
+
+func (m *RangeSearchRequest) Unmarshal(data []byte) error {
+	d := decoder{buf: data}
+	for {
+		ok, err := d.next()
+		if !ok {
+			return err
+		}
+
+		switch d.field {
+		case 1:
+			m.Vector, err = d.floats(m.Vector)
+		case 2:
+			m.Radius = d.double()
+		case 3:
+			m.Budget = int32(d.varint)
+		case 4:
+			m.Epsilon = d.double()
+		case 5:
+			m.IncludeVectors = d.varint != 0
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+type GetNeighboursRequest struct {
+	Id             int64
+	K              int32
+	Budget         int32
+	Epsilon        float64
+	IncludeVectors bool
+}
+
+func (m *GetNeighboursRequest) Reset()         { *m = GetNeighboursRequest{} }
+func (m *GetNeighboursRequest) String() string { return fmt.Sprintf("%+v", *m) }
+func (*GetNeighboursRequest) ProtoMessage()    {}
+
+func (m *GetNeighboursRequest) Marshal() ([]byte, error) {
+	var e encoder
+	e.int64(1, m.Id)
+	e.int64(2, int64(m.K))
+	e.int64(3, int64(m.Budget))
+	e.double(4, m.Epsilon)
+	e.bool(5, m.IncludeVectors)
+	return e.buf, nil
+}
+
+func (m *GetNeighboursRequest) Unmarshal(data []byte) error {
+	d := decoder{buf: data}
+	for {
+		ok, err := d.next()
+		if !ok {
+			return err
+		}
+
+		switch d.field {
+		case 1:
+			m.Id = int64(d.varint)
+		case 2:
+			m.K = int32(d.varint)
+		case 3:
+			m.Budget = int32(d.varint)
+		case 4:
+			m.Epsilon = d.double()
+		case 5:
+			m.IncludeVectors = d.varint != 0
+		}
+	}
+}
+
+type Neighbour struct {
+	Index    int64
+	Distance float64
+	Vector   []float32
+}
+
+func (m *Neighbour) Reset()         { *m = Neighbour{} }
+func (m *Neighbour) String() string { return fmt.Sprintf("%+v", *m) }
+func (*Neighbour) ProtoMessage()    {}
+
+func (m *Neighbour) Marshal() ([]byte, error) {
+	var e encoder
+	e.int64(1, m.Index)
+	e.double(2, m.Distance)
+	e.floats(3, m.Vector)
+	return e.buf, nil
+}
+
+func (m *Neighbour) Unmarshal(data []byte) error {
+	d := decoder{buf: data}
+	for {
+		ok, err := d.next()
+		if !ok {
+			return err
+		}
+
+		switch d.field {
+		case 1:
+			m.Index = int64(d.varint)
+		case 2:
+			m.Distance = d.double()
+		case 3:
+			m.Vector, err = d.floats(m.Vector)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+type SearchResponse struct {
+	Results []*Neighbour
+	Visited int64
+	Point   *Neighbour
+}
+
+func (m *SearchResponse) Reset()         { *m = SearchResponse{} }
+func (m *SearchResponse) String() string { return fmt.Sprintf("%+v", *m) }
+func (*SearchResponse) ProtoMessage()    {}
+
+func (m *SearchResponse) Marshal() ([]byte, error) {
+	var e encoder
+	for _, r := range m.Results {
+		if err := e.message(1, r); err != nil {
+			return nil, err
+		}
+	}
+	e.int64(2, m.Visited)
+	if m.Point != nil {
+		if err := e.message(3, m.Point); err != nil {
+			return nil, err
+		}
+	}
+	return e.buf, nil
+}
+
+func (m *SearchResponse) Unmarshal(data []byte) error {
+	d := decoder{buf: data}
+	for {
+		ok, err := d.next()
+		if !ok {
+			return err
+		}
+
+		switch d.field {
+		case 1:
+			r := &Neighbour{}
+			err = r.Unmarshal(d.bytes)
+			m.Results = append(m.Results, r)
+		case 2:
+			m.Visited = int64(d.varint)
+		case 3:
+			m.Point = &Neighbour{}
+			err = m.Point.Unmarshal(d.bytes)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+type BatchSearchResponse struct {
+	Responses []*SearchResponse
+}
+
+func (m *BatchSearchResponse) Reset()         { *m = BatchSearchResponse{} }
+func (m *BatchSearchResponse) String() string { return fmt.Sprintf("%+v", *m) }
+func (*BatchSearchResponse) ProtoMessage()    {}
+
+func (m *BatchSearchResponse) Marshal() ([]byte, error) {
+	var e encoder
+	for _, r := range m.Responses {
+		if err := e.message(1, r); err != nil {
+			return nil, err
+		}
+	}
+	return e.buf, nil
+}
+
+func (m *BatchSearchResponse) Unmarshal(data []byte) error {
+	d := decoder{buf: data}
+	for {
+		ok, err := d.next()
+		if !ok {
+			return err
+		}
+
+		if d.field == 1 {
+			r := &SearchResponse{}
+			if err := r.Unmarshal(d.bytes); err != nil {
+				return err
+			}
+			m.Responses = append(m.Responses, r)
+		}
+	}
+}
+
+type StatsRequest struct{}
+
+func (m *StatsRequest) Reset()                   { *m = StatsRequest{} }
+func (m *StatsRequest) String() string           { return "{}" }
+func (*StatsRequest) ProtoMessage()              {}
+func (m *StatsRequest) Marshal() ([]byte, error) { return nil, nil }
+func (m *StatsRequest) Unmarshal([]byte) error   { return nil }
+
+type StatsResponse struct {
+	Points   int64
+	Dims     int32
+	Searches int64
+	Visited  int64
+}
+
+func (m *StatsResponse) Reset()         { *m = StatsResponse{} }
+func (m *StatsResponse) String() string { return fmt.Sprintf("%+v", *m) }
+func (*StatsResponse) ProtoMessage()    {}
+
+func (m *StatsResponse) Marshal() ([]byte, error) {
+	var e encoder
+	e.int64(1, m.Points)
+	e.int64(2, int64(m.Dims))
+	e.int64(3, m.Searches)
+	e.int64(4, m.Visited)
+	return e.buf, nil
+}
+
+func (m *StatsResponse) Unmarshal(data []byte) error {
+	d := decoder{buf: data}
+	for {
+		ok, err := d.next()
+		if !ok {
+			return err
+		}
+
+		switch d.field {
+		case 1:
+			m.Points = int64(d.varint)
+		case 2:
+			m.Dims = int32(d.varint)
+		case 3:
+			m.Searches = int64(d.varint)
+		case 4:
+			m.Visited = int64(d.varint)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package rpc
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"net"
+	"testing"
+
+	"github.com/smhanov/nnsearch"
+	"google.golang.org/grpc"
+)
+
+func TestRemoteShards(t *testing.T) {
+	vectors := make([][]float32, 2000)
+	for i := range vectors {
+		vectors[i] = make([]float32, 8)
+		for j := range vectors[i] {
+			vectors[i][j] = rand.Float32()
+		}
+	}
+
+	all := nnsearch.NewVectorSpace(vectors, nnsearch.EuclideanDistance)
+	local := nnsearch.NewBruteForceIndex(nnsearch.NewVectorSpace(vectors[:1000], nnsearch.EuclideanDistance))
+	remote := nnsearch.NewGraphIndexWithOptions(nnsearch.NewVectorSpace(vectors[1000:], nnsearch.EuclideanDistance),
+		&nnsearch.GraphOptions{K: 20})
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+
+	s := grpc.NewServer()
+	Register(s, remote)
+	go s.Serve(lis)
+	defer s.Stop()
+
+	client, err := Dial(lis.Addr().String(), nnsearch.EuclideanDistance, grpc.WithInsecure())
+	if err != nil {
+		panic(err)
+	}
+	defer client.Close()
+
+	if client.Length() != 1000 || nnsearch.VectorOf(client.At(3))[5] != vectors[1003][5] {
+		log.Panicf("Remote index has the wrong points")
+	}
+
+	// a failed fetch is reported rather than panicking
+	if _, err = client.Point(context.Background(), 1000); err == nil {
+		log.Panicf("Fetched a point beyond the end of the remote index")
+	}
+	if pt := client.At(-1); pt != nil {
+		log.Panicf("Fetched point %v before the start of the remote index", pt)
+	}
+
+	// the fan-out over both shards finds the same distances as a search of all
+	// of the points
+	found := 0
+	for q := 0; q < 50; q++ {
+		target := all.At(rand.Intn(2000))
+		exact := nnsearch.NewBruteForceIndex(all).NearestNeighbours(target, 10, nil)
+		merged := nnsearch.SearchAll(target, 10, nil, local, client)
+		if len(merged) != 10 {
+			log.Panicf("Expected 10 results, got %d", len(merged))
+		}
+		for i := range merged {
+			if merged[i].Distance == exact[i].Distance {
+				found++
+			}
+		}
+	}
+	if found < 450 {
+		log.Panicf("Only %d of 500 distances match", found)
+	}
+
+	batch, err := client.BatchNearestNeighbours([]nnsearch.Point{all.At(1001), all.At(1002)}, 3, nil)
+	if err != nil {
+		panic(err)
+	}
+	if len(batch) != 2 || batch[1][0].Index != 2 || batch[1][0].Distance != 0 {
+		log.Panicf("Unexpected batch results %v", batch)
+	}
+
+	neighbours, err := client.Neighbours(2, 3, nil)
+	if err != nil {
+		panic(err)
+	}
+	if len(neighbours) != 3 || neighbours[0].Index == 2 || neighbours[0].Distance != batch[1][1].Distance {
+		log.Panicf("Unexpected neighbours %v", neighbours)
+	}
+
+	within, err := client.RangeSearch(all.At(1002), batch[1][2].Distance, nil)
+	if err != nil {
+		panic(err)
+	}
+	if len(within) != 3 {
+		log.Panicf("Expected 3 points in range, got %v", within)
+	}
+
+	stats, err := client.Stats(context.Background())
+	if err != nil {
+		panic(err)
+	}
+	if stats.Points != 1000 || stats.Dims != 8 || stats.Searches < 50 {
+		log.Panicf("Unexpected stats %v", stats)
+	}
+}
+
+func TestMessages(t *testing.T) {
+	in := &SearchResponse{
+		Results: []*Neighbour{{Index: 5, Distance: 1.5, Vector: []float32{1, -2}}, {}},
+		Visited: 300,
+		Point:   &Neighbour{Index: -1},
+	}
+
+	data, err := in.Marshal()
+	if err != nil {
+		panic(err)
+	}
+
+	var out SearchResponse
+	err = out.Unmarshal(data)
+	if err != nil {
+		panic(err)
+	}
+
+	if len(out.Results) != 2 || out.Results[0].Vector[1] != -2 || out.Results[0].Distance != 1.5 ||
+		out.Visited != 300 || out.Point.Index != -1 {
+		log.Panicf("Decoded %v, expected %v", &out, in)
+	}
+
+	req := &SearchRequest{K: -3, Epsilon: 1.25, IncludeVectors: true}
+	data, _ = req.Marshal()
+	var decoded SearchRequest
+	if err = decoded.Unmarshal(data); err != nil || decoded.K != -3 || !decoded.IncludeVectors {
+		log.Panicf("Decoded %v, expected %v", &decoded, req)
+	}
+}
+
+// plainSpace holds points that are bare vectors rather than DenseVectors.
+type plainSpace [][]float32
+
+func (ps plainSpace) Length() int {
+	return len(ps)
+}
+
+func (ps plainSpace) At(i int) nnsearch.Point {
+	return ps[i]
+}
+
+func (ps plainSpace) Distance(p1, p2 nnsearch.Point) float64 {
+	return nnsearch.EuclideanDistance(nnsearch.VectorOf(p1), nnsearch.VectorOf(p2))
+}
+
+func TestNeighboursOfPlainVectors(t *testing.T) {
+	space := make(plainSpace, 200)
+	for i := range space {
+		space[i] = []float32{rand.Float32(), rand.Float32()}
+	}
+
+	srv := NewServer(nnsearch.NewBruteForceIndex(space))
+	resp, err := srv.GetNeighbours(context.Background(), &GetNeighboursRequest{Id: 17, K: 5})
+	if err != nil {
+		panic(err)
+	}
+	if len(resp.Results) != 5 {
+		log.Panicf("Expected 5 neighbours, got %v", resp.Results)
+	}
+	for _, n := range resp.Results {
+		if n.Index == 17 {
+			log.Panicf("Point 17 returned as its own neighbour")
+		}
+	}
+
+	// fetching a point is not a search
+	_, err = srv.GetNeighbours(context.Background(), &GetNeighboursRequest{Id: 3})
+	if err != nil {
+		panic(err)
+	}
+	stats, _ := srv.Stats(context.Background(), &StatsRequest{})
+	if stats.Searches != 1 {
+		log.Panicf("Counted %d searches, expected 1", stats.Searches)
+	}
+}
@@ -0,0 +1,187 @@
+// Package rpc serves nearest neighbour searches over gRPC, and provides a
+// client that searches a remote index as if it were local.
+package rpc
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/smhanov/nnsearch"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Server implements the Search service over any SpaceIndex. Range searches
+// need an index that is also a graph.
+type Server struct {
+	index    nnsearch.SpaceIndex
+	searches int64
+	visited  int64
+}
+
+// NewServer returns a server that searches the index.
+func NewServer(index nnsearch.SpaceIndex) *Server {
+	return &Server{index: index}
+}
+
+// Register serves the index on a gRPC server.
+func Register(s *grpc.Server, index nnsearch.SpaceIndex) *Server {
+	srv := NewServer(index)
+	RegisterSearchServer(s, srv)
+	return srv
+}
+
+func (s *Server) options(ctx context.Context, budget int32, epsilon float64, stats *nnsearch.SearchStats) *nnsearch.SearchOptions {
+	return &nnsearch.SearchOptions{
+		Ctx:     ctx,
+		Stats:   stats,
+		Budget:  int(budget),
+		Epsilon: epsilon,
+	}
+}
+
+// contextError returns the status for a search cut short by its context.
+func contextError(ctx context.Context) error {
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		return status.Error(codes.DeadlineExceeded, "search timed out")
+	case context.Canceled:
+		return status.Error(codes.Canceled, "search cancelled")
+	}
+	return nil
+}
+
+// target checks the dimensions of a query vector and makes it into a point.
+func (s *Server) target(vector []float32) (nnsearch.Point, error) {
+	if len(vector) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "no vector given")
+	}
+
+	if s.index.Length() > 0 {
+		if d := len(nnsearch.VectorOf(s.index.At(0))); d != len(vector) {
+			return nil, status.Errorf(codes.InvalidArgument, "vector has %d dimensions, expected %d", len(vector), d)
+		}
+	}
+
+	return &nnsearch.DenseVector{Index: -1, Vector: vector}, nil
+}
+
+// record counts a search in the stats of the server.
+func (s *Server) record(stats *nnsearch.SearchStats) {
+	atomic.AddInt64(&s.searches, 1)
+	atomic.AddInt64(&s.visited, int64(stats.Visited))
+}
+
+func (s *Server) response(results []nnsearch.PointDistance, stats *nnsearch.SearchStats, includeVectors bool) *SearchResponse {
+	resp := &SearchResponse{
+		Results: make([]*Neighbour, len(results)),
+		Visited: int64(stats.Visited),
+	}
+	for i, r := range results {
+		resp.Results[i] = neighbour(r.Index, r.Distance, r.Point, includeVectors)
+	}
+	return resp
+}
+
+func neighbour(index int, distance float64, pt nnsearch.Point, includeVector bool) *Neighbour {
+	n := &Neighbour{
+		Index:    int64(index),
+		Distance: distance,
+	}
+	if includeVector {
+		n.Vector = nnsearch.VectorOf(pt)
+	}
+	return n
+}
+
+func (s *Server) Search(ctx context.Context, req *SearchRequest) (*SearchResponse, error) {
+	target, err := s.target(req.Vector)
+	if err != nil {
+		return nil, err
+	}
+
+	k := int(req.K)
+	if k <= 0 {
+		k = 10
+	}
+
+	var stats nnsearch.SearchStats
+	results := s.index.NearestNeighbours(target, k, s.options(ctx, req.Budget, req.Epsilon, &stats))
+	s.record(&stats)
+	return s.response(results, &stats, req.IncludeVectors), contextError(ctx)
+}
+
+func (s *Server) BatchSearch(ctx context.Context, req *BatchSearchRequest) (*BatchSearchResponse, error) {
+	resp := &BatchSearchResponse{
+		Responses: make([]*SearchResponse, len(req.Queries)),
+	}
+
+	errs := make([]error, len(req.Queries))
+	nnsearch.ForkLoop(len(req.Queries), func(i int) {
+		resp.Responses[i], errs[i] = s.Search(ctx, req.Queries[i])
+	})
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+func (s *Server) RangeSearch(ctx context.Context, req *RangeSearchRequest) (*SearchResponse, error) {
+	g, ok := s.index.(nnsearch.IGraph)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, "the index does not support range searches")
+	}
+
+	target, err := s.target(req.Vector)
+	if err != nil {
+		return nil, err
+	}
+
+	var stats nnsearch.SearchStats
+	results := nnsearch.RangeSearch(g, target, req.Radius, s.options(ctx, req.Budget, req.Epsilon, &stats))
+	s.record(&stats)
+	return s.response(results, &stats, req.IncludeVectors), contextError(ctx)
+}
+
+func (s *Server) GetNeighbours(ctx context.Context, req *GetNeighboursRequest) (*SearchResponse, error) {
+	id := int(req.Id)
+	if id < 0 || id >= s.index.Length() {
+		return nil, status.Errorf(codes.NotFound, "no point %d", id)
+	}
+
+	pt := s.index.At(id)
+	var stats nnsearch.SearchStats
+	var results []nnsearch.PointDistance
+	if req.K > 0 {
+		// the point itself is found among its neighbours, so one more is
+		// asked for and it is dropped
+		k := int(req.K)
+		options := s.options(ctx, req.Budget, req.Epsilon, &stats)
+		for _, r := range s.index.NearestNeighbours(pt, k+1, options) {
+			if r.Index != id && len(results) < k {
+				results = append(results, r)
+			}
+		}
+		s.record(&stats)
+	}
+
+	resp := s.response(results, &stats, req.IncludeVectors)
+	resp.Point = neighbour(id, 0, pt, req.IncludeVectors)
+	return resp, contextError(ctx)
+}
+
+func (s *Server) Stats(ctx context.Context, req *StatsRequest) (*StatsResponse, error) {
+	resp := &StatsResponse{
+		Points:   int64(s.index.Length()),
+		Searches: atomic.LoadInt64(&s.searches),
+		Visited:  atomic.LoadInt64(&s.visited),
+	}
+	if resp.Points > 0 {
+		resp.Dims = int32(len(nnsearch.VectorOf(s.index.At(0))))
+	}
+	return resp, nil
+}
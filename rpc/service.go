@@ -0,0 +1,126 @@
+package rpc
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+)
+
+// SearchServer is the server API of the Search service.
+type SearchServer interface {
+	Search(context.Context, *SearchRequest) (*SearchResponse, error)
+	BatchSearch(context.Context, *BatchSearchRequest) (*BatchSearchResponse, error)
+	RangeSearch(context.Context, *RangeSearchRequest) (*SearchResponse, error)
+	GetNeighbours(context.Context, *GetNeighboursRequest) (*SearchResponse, error)
+	Stats(context.Context, *StatsRequest) (*StatsResponse, error)
+}
+
+// RegisterSearchServer registers the implementation of the Search service
+// with a gRPC server.
+func RegisterSearchServer(s *grpc.Server, srv SearchServer) {
+	s.RegisterService(&searchServiceDesc, srv)
+}
+
+func unaryHandler(method string, newRequest func() interface{},
+	call func(SearchServer, context.Context, interface{}) (interface{}, error)) grpc.MethodDesc {
+
+	return grpc.MethodDesc{
+		MethodName: method,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error,
+			interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+
+			in := newRequest()
+			if err := dec(in); err != nil {
+				return nil, err
+			}
+
+			if interceptor == nil {
+				return call(srv.(SearchServer), ctx, in)
+			}
+
+			info := &grpc.UnaryServerInfo{
+				Server:     srv,
+				FullMethod: "/nnsearch.Search/" + method,
+			}
+			return interceptor(ctx, in, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+				return call(srv.(SearchServer), ctx, req)
+			})
+		},
+	}
+}
+
+var searchServiceDesc = grpc.ServiceDesc{
+	ServiceName: "nnsearch.Search",
+	HandlerType: (*SearchServer)(nil),
+	Methods: []grpc.MethodDesc{
+		unaryHandler("Search", func() interface{} { return new(SearchRequest) },
+			func(s SearchServer, ctx context.Context, in interface{}) (interface{}, error) {
+				return s.Search(ctx, in.(*SearchRequest))
+			}),
+		unaryHandler("BatchSearch", func() interface{} { return new(BatchSearchRequest) },
+			func(s SearchServer, ctx context.Context, in interface{}) (interface{}, error) {
+				return s.BatchSearch(ctx, in.(*BatchSearchRequest))
+			}),
+		unaryHandler("RangeSearch", func() interface{} { return new(RangeSearchRequest) },
+			func(s SearchServer, ctx context.Context, in interface{}) (interface{}, error) {
+				return s.RangeSearch(ctx, in.(*RangeSearchRequest))
+			}),
+		unaryHandler("GetNeighbours", func() interface{} { return new(GetNeighboursRequest) },
+			func(s SearchServer, ctx context.Context, in interface{}) (interface{}, error) {
+				return s.GetNeighbours(ctx, in.(*GetNeighboursRequest))
+			}),
+		unaryHandler("Stats", func() interface{} { return new(StatsRequest) },
+			func(s SearchServer, ctx context.Context, in interface{}) (interface{}, error) {
+				return s.Stats(ctx, in.(*StatsRequest))
+			}),
+	},
+	Metadata: "nnsearch.proto",
+}
+
+// SearchClient is the client API of the Search service.
+type SearchClient interface {
+	Search(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (*SearchResponse, error)
+	BatchSearch(ctx context.Context, in *BatchSearchRequest, opts ...grpc.CallOption) (*BatchSearchResponse, error)
+	RangeSearch(ctx context.Context, in *RangeSearchRequest, opts ...grpc.CallOption) (*SearchResponse, error)
+	GetNeighbours(ctx context.Context, in *GetNeighboursRequest, opts ...grpc.CallOption) (*SearchResponse, error)
+	Stats(ctx context.Context, in *StatsRequest, opts ...grpc.CallOption) (*StatsResponse, error)
+}
+
+type searchClient struct {
+	cc *grpc.ClientConn
+}
+
+// NewSearchClient returns a client of the Search service on a connection.
+func NewSearchClient(cc *grpc.ClientConn) SearchClient {
+	return &searchClient{cc}
+}
+
+func (c *searchClient) Search(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (*SearchResponse, error) {
+	out := new(SearchResponse)
+	err := c.cc.Invoke(ctx, "/nnsearch.Search/Search", in, out, opts...)
+	return out, err
+}
+
+func (c *searchClient) BatchSearch(ctx context.Context, in *BatchSearchRequest, opts ...grpc.CallOption) (*BatchSearchResponse, error) {
+	out := new(BatchSearchResponse)
+	err := c.cc.Invoke(ctx, "/nnsearch.Search/BatchSearch", in, out, opts...)
+	return out, err
+}
+
+func (c *searchClient) RangeSearch(ctx context.Context, in *RangeSearchRequest, opts ...grpc.CallOption) (*SearchResponse, error) {
+	out := new(SearchResponse)
+	err := c.cc.Invoke(ctx, "/nnsearch.Search/RangeSearch", in, out, opts...)
+	return out, err
+}
+
+func (c *searchClient) GetNeighbours(ctx context.Context, in *GetNeighboursRequest, opts ...grpc.CallOption) (*SearchResponse, error) {
+	out := new(SearchResponse)
+	err := c.cc.Invoke(ctx, "/nnsearch.Search/GetNeighbours", in, out, opts...)
+	return out, err
+}
+
+func (c *searchClient) Stats(ctx context.Context, in *StatsRequest, opts ...grpc.CallOption) (*StatsResponse, error) {
+	out := new(StatsResponse)
+	err := c.cc.Invoke(ctx, "/nnsearch.Search/Stats", in, out, opts...)
+	return out, err
+}
@@ -0,0 +1,176 @@
+package rpc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// Protocol buffer wire types. The encoding below is checked against the
+// protobuf library by wire_test.go.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// encoder appends fields in the protocol buffer wire format. Fields with zero
+// values are left out, as in proto3.
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) tag(field int, wireType int) {
+	e.uvarint(uint64(field)<<3 | uint64(wireType))
+}
+
+func (e *encoder) uvarint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	e.buf = append(e.buf, b[:n]...)
+}
+
+func (e *encoder) int64(field int, v int64) {
+	if v != 0 {
+		e.tag(field, wireVarint)
+		e.uvarint(uint64(v))
+	}
+}
+
+func (e *encoder) bool(field int, v bool) {
+	if v {
+		e.tag(field, wireVarint)
+		e.uvarint(1)
+	}
+}
+
+func (e *encoder) double(field int, v float64) {
+	if v != 0 {
+		e.tag(field, wireFixed64)
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], math.Float64bits(v))
+		e.buf = append(e.buf, b[:]...)
+	}
+}
+
+// floats writes a packed repeated float field.
+func (e *encoder) floats(field int, v []float32) {
+	if len(v) == 0 {
+		return
+	}
+
+	e.tag(field, wireBytes)
+	e.uvarint(uint64(4 * len(v)))
+	for _, x := range v {
+		var b [4]byte
+		binary.LittleEndian.PutUint32(b[:], math.Float32bits(x))
+		e.buf = append(e.buf, b[:]...)
+	}
+}
+
+// message writes an embedded message. Unlike other fields, it is written even
+// when empty, so that repeated messages keep their positions.
+func (e *encoder) message(field int, m interface{ Marshal() ([]byte, error) }) error {
+	data, err := m.Marshal()
+	if err != nil {
+		return err
+	}
+
+	e.tag(field, wireBytes)
+	e.uvarint(uint64(len(data)))
+	e.buf = append(e.buf, data...)
+	return nil
+}
+
+// decoder reads the fields of a message in the protocol buffer wire format.
+type decoder struct {
+	buf      []byte
+	field    int
+	wireType int
+
+	// the value of the current field, depending on its wire type
+	varint uint64
+	fixed  uint64
+	bytes  []byte
+}
+
+func (d *decoder) uvarint() (uint64, error) {
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		return 0, fmt.Errorf("malformed varint")
+	}
+	d.buf = d.buf[n:]
+	return v, nil
+}
+
+// next reads the next field, returning false at the end of the message.
+func (d *decoder) next() (bool, error) {
+	if len(d.buf) == 0 {
+		return false, nil
+	}
+
+	tag, err := d.uvarint()
+	if err != nil {
+		return false, err
+	}
+	d.field = int(tag >> 3)
+	d.wireType = int(tag & 7)
+
+	switch d.wireType {
+	case wireVarint:
+		d.varint, err = d.uvarint()
+		return err == nil, err
+
+	case wireFixed64:
+		if len(d.buf) < 8 {
+			return false, fmt.Errorf("truncated field %d", d.field)
+		}
+		d.fixed = binary.LittleEndian.Uint64(d.buf)
+		d.buf = d.buf[8:]
+
+	case wireFixed32:
+		if len(d.buf) < 4 {
+			return false, fmt.Errorf("truncated field %d", d.field)
+		}
+		d.fixed = uint64(binary.LittleEndian.Uint32(d.buf))
+		d.buf = d.buf[4:]
+
+	case wireBytes:
+		n, err := d.uvarint()
+		if err != nil {
+			return false, err
+		}
+		if uint64(len(d.buf)) < n {
+			return false, fmt.Errorf("truncated field %d", d.field)
+		}
+		d.bytes = d.buf[:n]
+		d.buf = d.buf[n:]
+
+	default:
+		return false, fmt.Errorf("unsupported wire type %d in field %d", d.wireType, d.field)
+	}
+
+	return true, nil
+}
+
+func (d *decoder) double() float64 {
+	return math.Float64frombits(d.fixed)
+}
+
+// floats appends the values of a repeated float field, which may be packed.
+func (d *decoder) floats(v []float32) ([]float32, error) {
+	switch d.wireType {
+	case wireFixed32:
+		return append(v, math.Float32frombits(uint32(d.fixed))), nil
+	case wireBytes:
+		if len(d.bytes)%4 != 0 {
+			return nil, fmt.Errorf("malformed packed floats in field %d", d.field)
+		}
+		for i := 0; i < len(d.bytes); i += 4 {
+			v = append(v, math.Float32frombits(binary.LittleEndian.Uint32(d.bytes[i:])))
+		}
+		return v, nil
+	}
+	return nil, fmt.Errorf("unexpected wire type %d for field %d", d.wireType, d.field)
+}
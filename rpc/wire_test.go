@@ -0,0 +1,145 @@
+package rpc
+
+import (
+	"log"
+	"reflect"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// The messages below are declared with the struct tags protoc-gen-go v1.3
+// writes for nnsearch.proto, so that the protobuf library encodes them by
+// reflection. They check the hand written messages against it. When a message
+// in nnsearch.proto changes, copy its tags from the output of
+//
+//	protoc --go_out=plugins=grpc:. nnsearch.proto
+
+type pbSearchRequest struct {
+	Vector         []float32 `protobuf:"fixed32,1,rep,packed,name=vector,proto3"`
+	K              int32     `protobuf:"varint,2,opt,name=k,proto3"`
+	Budget         int32     `protobuf:"varint,3,opt,name=budget,proto3"`
+	Epsilon        float64   `protobuf:"fixed64,4,opt,name=epsilon,proto3"`
+	IncludeVectors bool      `protobuf:"varint,5,opt,name=include_vectors,json=includeVectors,proto3"`
+}
+
+func (m *pbSearchRequest) Reset()         { *m = pbSearchRequest{} }
+func (m *pbSearchRequest) String() string { return proto.CompactTextString(m) }
+func (*pbSearchRequest) ProtoMessage()    {}
+
+type pbBatchSearchRequest struct {
+	Queries []*pbSearchRequest `protobuf:"bytes,1,rep,name=queries,proto3"`
+}
+
+func (m *pbBatchSearchRequest) Reset()         { *m = pbBatchSearchRequest{} }
+func (m *pbBatchSearchRequest) String() string { return proto.CompactTextString(m) }
+func (*pbBatchSearchRequest) ProtoMessage()    {}
+
+type pbGetNeighboursRequest struct {
+	Id             int64   `protobuf:"varint,1,opt,name=id,proto3"`
+	K              int32   `protobuf:"varint,2,opt,name=k,proto3"`
+	Budget         int32   `protobuf:"varint,3,opt,name=budget,proto3"`
+	Epsilon        float64 `protobuf:"fixed64,4,opt,name=epsilon,proto3"`
+	IncludeVectors bool    `protobuf:"varint,5,opt,name=include_vectors,json=includeVectors,proto3"`
+}
+
+func (m *pbGetNeighboursRequest) Reset()         { *m = pbGetNeighboursRequest{} }
+func (m *pbGetNeighboursRequest) String() string { return proto.CompactTextString(m) }
+func (*pbGetNeighboursRequest) ProtoMessage()    {}
+
+type pbNeighbour struct {
+	Index    int64     `protobuf:"varint,1,opt,name=index,proto3"`
+	Distance float64   `protobuf:"fixed64,2,opt,name=distance,proto3"`
+	Vector   []float32 `protobuf:"fixed32,3,rep,packed,name=vector,proto3"`
+}
+
+func (m *pbNeighbour) Reset()         { *m = pbNeighbour{} }
+func (m *pbNeighbour) String() string { return proto.CompactTextString(m) }
+func (*pbNeighbour) ProtoMessage()    {}
+
+type pbSearchResponse struct {
+	Results []*pbNeighbour `protobuf:"bytes,1,rep,name=results,proto3"`
+	Visited int64          `protobuf:"varint,2,opt,name=visited,proto3"`
+	Point   *pbNeighbour   `protobuf:"bytes,3,opt,name=point,proto3"`
+}
+
+func (m *pbSearchResponse) Reset()         { *m = pbSearchResponse{} }
+func (m *pbSearchResponse) String() string { return proto.CompactTextString(m) }
+func (*pbSearchResponse) ProtoMessage()    {}
+
+type pbStatsResponse struct {
+	Points   int64 `protobuf:"varint,1,opt,name=points,proto3"`
+	Dims     int32 `protobuf:"varint,2,opt,name=dims,proto3"`
+	Searches int64 `protobuf:"varint,3,opt,name=searches,proto3"`
+	Visited  int64 `protobuf:"varint,4,opt,name=visited,proto3"`
+}
+
+func (m *pbStatsResponse) Reset()         { *m = pbStatsResponse{} }
+func (m *pbStatsResponse) String() string { return proto.CompactTextString(m) }
+func (*pbStatsResponse) ProtoMessage()    {}
+
+type wireMessage interface {
+	Marshal() ([]byte, error)
+	Unmarshal([]byte) error
+}
+
+// roundTrip encodes ours and decodes it into the reference message, which must
+// then equal ref. It then encodes ref and decodes it into a new message of the
+// type of ours, which must equal ours.
+func roundTrip(ours wireMessage, ref proto.Message) {
+	data, err := ours.Marshal()
+	if err != nil {
+		panic(err)
+	}
+
+	decoded := reflect.New(reflect.TypeOf(ref).Elem()).Interface().(proto.Message)
+	if err = proto.Unmarshal(data, decoded); err != nil {
+		log.Panicf("protobuf cannot decode %T: %v", ours, err)
+	}
+	if !proto.Equal(decoded, ref) {
+		log.Panicf("protobuf decoded %v, expected %v", decoded, ref)
+	}
+
+	data, err = proto.Marshal(ref)
+	if err != nil {
+		panic(err)
+	}
+
+	back := reflect.New(reflect.TypeOf(ours).Elem()).Interface().(wireMessage)
+	if err = back.Unmarshal(data); err != nil {
+		log.Panicf("cannot decode %T from protobuf: %v", ours, err)
+	}
+	if !reflect.DeepEqual(back, ours) {
+		log.Panicf("Decoded %v from protobuf, expected %v", back, ours)
+	}
+}
+
+func TestWireCompatibility(t *testing.T) {
+	roundTrip(
+		&SearchRequest{Vector: []float32{1, -2.5, 0}, K: -3, Budget: 1000, Epsilon: 1.25, IncludeVectors: true},
+		&pbSearchRequest{Vector: []float32{1, -2.5, 0}, K: -3, Budget: 1000, Epsilon: 1.25, IncludeVectors: true})
+
+	roundTrip(
+		&BatchSearchRequest{Queries: []*SearchRequest{{K: 5}, {Vector: []float32{3}}}},
+		&pbBatchSearchRequest{Queries: []*pbSearchRequest{{K: 5}, {Vector: []float32{3}}}})
+
+	roundTrip(
+		&GetNeighboursRequest{Id: 1 << 40, K: 10, Epsilon: -1},
+		&pbGetNeighboursRequest{Id: 1 << 40, K: 10, Epsilon: -1})
+
+	roundTrip(
+		&SearchResponse{
+			Results: []*Neighbour{{Index: 5, Distance: 1.5, Vector: []float32{1, -2}}, {Index: 300}},
+			Visited: 300,
+			Point:   &Neighbour{Index: -1},
+		},
+		&pbSearchResponse{
+			Results: []*pbNeighbour{{Index: 5, Distance: 1.5, Vector: []float32{1, -2}}, {Index: 300}},
+			Visited: 300,
+			Point:   &pbNeighbour{Index: -1},
+		})
+
+	roundTrip(
+		&StatsResponse{Points: 1000000, Dims: 128, Searches: 7, Visited: 1 << 33},
+		&pbStatsResponse{Points: 1000000, Dims: 128, Searches: 7, Visited: 1 << 33})
+}
@@ -0,0 +1,271 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/smhanov/nnsearch"
+)
+
+// searchRequest is the body of a knn or range request. For neighbour requests
+// the same fields are read from the query string.
+type searchRequest struct {
+	Vector    []float32 `json:"vector"`
+	K         int       `json:"k"`
+	Radius    float64   `json:"radius"`
+	Budget    int       `json:"budget"`
+	Epsilon   float64   `json:"epsilon"`
+	TimeoutMS int       `json:"timeout_ms"`
+}
+
+type searchResult struct {
+	Index    int     `json:"index"`
+	Distance float64 `json:"distance"`
+}
+
+type searchResponse struct {
+	Results []searchResult `json:"results"`
+	Visited int            `json:"visited"`
+}
+
+type indexInfo struct {
+	Name   string `json:"name"`
+	Points int    `json:"points"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// httpError is an error with the status code to respond with. For
+// StatusMethodNotAllowed, allow lists the methods that are accepted.
+type httpError struct {
+	status int
+	err    error
+	allow  []string
+}
+
+func (e *httpError) Error() string {
+	return e.err.Error()
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return &httpError{status: http.StatusBadRequest, err: fmt.Errorf(format, args...)}
+}
+
+func notFound(format string, args ...interface{}) error {
+	return &httpError{status: http.StatusNotFound, err: fmt.Errorf(format, args...)}
+}
+
+// checkMethod returns an error unless the request uses one of the methods.
+func checkMethod(r *http.Request, methods ...string) error {
+	for _, m := range methods {
+		if r.Method == m {
+			return nil
+		}
+	}
+	return &httpError{http.StatusMethodNotAllowed,
+		fmt.Errorf("method %s not allowed for %s", r.Method, r.URL.Path), methods}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	result, err := s.route(r)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if he, ok := err.(*httpError); ok {
+			status = he.status
+			if len(he.allow) > 0 {
+				w.Header().Set("Allow", strings.Join(he.allow, ", "))
+			}
+		}
+		writeJSON(w, status, &errorResponse{err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *Server) route(r *http.Request) (interface{}, error) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) == 0 || parts[0] != "indices" {
+		return nil, notFound("no such endpoint %s", r.URL.Path)
+	}
+
+	if len(parts) == 1 {
+		return s.list(), nil
+	}
+
+	name := parts[1]
+	switch {
+	case len(parts) == 3 && parts[2] == "reload":
+		if err := checkMethod(r, http.MethodPost); err != nil {
+			return nil, err
+		}
+		if err := s.Reload(name); err != nil {
+			return nil, err
+		}
+		return s.list(), nil
+
+	case len(parts) == 3 && (parts[2] == "knn" || parts[2] == "range"):
+		if err := checkMethod(r, http.MethodGet, http.MethodPost); err != nil {
+			return nil, err
+		}
+		var req searchRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return nil, badRequest("invalid request: %v", err)
+		}
+		return s.search(r.Context(), name, parts[2], &req, -1)
+
+	case len(parts) == 4 && parts[2] == "neighbours":
+		if err := checkMethod(r, http.MethodGet); err != nil {
+			return nil, err
+		}
+		id, err := strconv.Atoi(parts[3])
+		if err != nil {
+			return nil, badRequest("invalid id %q", parts[3])
+		}
+
+		req, err := queryRequest(r)
+		if err != nil {
+			return nil, err
+		}
+		return s.search(r.Context(), name, "knn", req, id)
+	}
+
+	return nil, notFound("no such endpoint %s", r.URL.Path)
+}
+
+// queryRequest reads the search options from the query string.
+func queryRequest(r *http.Request) (*searchRequest, error) {
+	var req searchRequest
+	var err error
+	q := r.URL.Query()
+	ints := map[string]*int{"k": &req.K, "budget": &req.Budget, "timeout_ms": &req.TimeoutMS}
+	for key, v := range ints {
+		if s := q.Get(key); s != "" {
+			if *v, err = strconv.Atoi(s); err != nil {
+				return nil, badRequest("invalid %s %q", key, s)
+			}
+		}
+	}
+
+	if s := q.Get("epsilon"); s != "" {
+		if req.Epsilon, err = strconv.ParseFloat(s, 64); err != nil {
+			return nil, badRequest("invalid epsilon %q", s)
+		}
+	}
+	return &req, nil
+}
+
+func (s *Server) list() []indexInfo {
+	var infos []indexInfo
+	for _, name := range s.Names() {
+		h := s.acquire(name)
+		if h == nil {
+			continue
+		}
+		infos = append(infos, indexInfo{name, h.index.Length()})
+		h.release()
+	}
+	return infos
+}
+
+// search answers a knn or range request. If id is not negative, the target is
+// the point with that index, which is left out of the results.
+func (s *Server) search(ctx context.Context, name, kind string, req *searchRequest, id int) (interface{}, error) {
+	h := s.acquire(name)
+	if h == nil {
+		return nil, notFound("no index named %q", name)
+	}
+	defer h.release()
+	index := h.index
+
+	var target nnsearch.Point
+	if id >= 0 {
+		if id >= index.Length() {
+			return nil, notFound("no point %d", id)
+		}
+		target = index.At(id)
+	} else {
+		if len(req.Vector) == 0 {
+			return nil, badRequest("no vector given")
+		}
+		if index.Length() > 0 {
+			if d := len(nnsearch.VectorOf(index.At(0))); d != len(req.Vector) {
+				return nil, badRequest("vector has %d dimensions, expected %d", len(req.Vector), d)
+			}
+		}
+		target = &nnsearch.DenseVector{Index: -1, Vector: req.Vector}
+	}
+
+	if req.TimeoutMS > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(req.TimeoutMS)*time.Millisecond)
+		defer cancel()
+	}
+
+	var stats nnsearch.SearchStats
+	options := &nnsearch.SearchOptions{
+		Ctx:     ctx,
+		Stats:   &stats,
+		Budget:  req.Budget,
+		Epsilon: req.Epsilon,
+	}
+
+	k := req.K
+	if k <= 0 {
+		k = 10
+	}
+
+	// the point itself is found among its neighbours, so one more is asked
+	// for and it is dropped below
+	if id >= 0 {
+		k++
+	}
+
+	var results []nnsearch.PointDistance
+	if kind == "range" {
+		g, ok := index.(nnsearch.IGraph)
+		if !ok {
+			return nil, badRequest("index %q does not support range searches", name)
+		}
+		if req.Radius <= 0 {
+			return nil, badRequest("no radius given")
+		}
+		results = nnsearch.RangeSearch(g, target, req.Radius, options)
+	} else {
+		results = index.NearestNeighbours(target, k, options)
+	}
+
+	if id >= 0 {
+		kept := results[:0]
+		for _, r := range results {
+			if r.Index != id {
+				kept = append(kept, r)
+			}
+		}
+		if len(kept) == k {
+			kept = kept[:k-1]
+		}
+		results = kept
+	}
+
+	resp := &searchResponse{
+		Results: make([]searchResult, len(results)),
+		Visited: stats.Visited,
+	}
+	for i, r := range results {
+		resp.Results[i] = searchResult{r.Index, r.Distance}
+	}
+	return resp, nil
+}
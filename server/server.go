@@ -0,0 +1,235 @@
+// Package server serves nearest neighbour searches over HTTP with JSON
+// requests and responses.
+//
+// Each index is served under its name:
+//
+//	GET  /indices                              list the indices
+//	POST /indices/{name}/knn                   nearest neighbours of a vector
+//	POST /indices/{name}/range                 points within a radius of a vector
+//	GET  /indices/{name}/neighbours/{id}?k=10  nearest neighbours of a point
+//	POST /indices/{name}/reload                reopen the files of the index
+//
+// Searches accept k, budget, epsilon and timeout_ms, which map onto
+// nnsearch.SearchOptions.
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/smhanov/nnsearch"
+)
+
+// Opener opens an index. It is called when the index is loaded, and again
+// each time it is reloaded. If the index implements io.Closer it is closed
+// once it has been replaced and its last search has finished.
+type Opener func() (nnsearch.SpaceIndex, error)
+
+// searchableGraph is a graph that is also a SpaceIndex, as frozen graphs are.
+type searchableGraph interface {
+	nnsearch.IGraph
+	NearestNeighbours(target nnsearch.Point, k int, options *nnsearch.SearchOptions) []nnsearch.PointDistance
+	Write(w io.Writer) (int64, error)
+}
+
+// graphIndex is a frozen graph over frozen vectors, which closes both.
+type graphIndex struct {
+	searchableGraph
+	vectors io.Closer
+}
+
+func (gi *graphIndex) Close() error {
+	err := gi.searchableGraph.(io.Closer).Close()
+	if verr := gi.vectors.Close(); err == nil {
+		err = verr
+	}
+	return err
+}
+
+// OpenGraph returns an opener for a graph saved with Save or written by
+// BuildGraphOutOfCore, over vectors written by nnsearch.FreezeVectors.
+func OpenGraph(graphFile, vectorsFile string, distance nnsearch.VectorDistance) Opener {
+	return func() (nnsearch.SpaceIndex, error) {
+		vectors, err := nnsearch.OpenFrozenVectors(vectorsFile, distance)
+		if err != nil {
+			return nil, err
+		}
+
+		index, err := nnsearch.LoadGraphIndex(graphFile, vectors)
+		if err != nil {
+			vectors.Close()
+			return nil, err
+		}
+
+		return &graphIndex{index.(searchableGraph), vectors}, nil
+	}
+}
+
+// handle is a reference counted index. The server holds one reference while
+// the index is installed, and each search holds another while it runs.
+type handle struct {
+	index nnsearch.SpaceIndex
+	refs  int32
+}
+
+func (h *handle) acquire() {
+	atomic.AddInt32(&h.refs, 1)
+}
+
+func (h *handle) release() {
+	if atomic.AddInt32(&h.refs, -1) == 0 {
+		if c, ok := h.index.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				log.Printf("Error closing index: %v", err)
+			}
+		}
+	}
+}
+
+type entry struct {
+	open    Opener
+	current *handle
+}
+
+// Server holds a set of named indices and answers searches over them.
+type Server struct {
+	mutex   sync.RWMutex
+	indices map[string]*entry
+	http    *http.Server
+	reload  sync.Mutex
+}
+
+// New returns a server without any indices.
+func New() *Server {
+	return &Server{
+		indices: make(map[string]*entry),
+	}
+}
+
+// Load opens an index and serves it under the given name, replacing any index
+// already served under that name.
+func (s *Server) Load(name string, open Opener) error {
+	s.reload.Lock()
+	defer s.reload.Unlock()
+
+	index, err := open()
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	old := s.indices[name]
+	s.indices[name] = &entry{
+		open:    open,
+		current: &handle{index: index, refs: 1},
+	}
+	s.mutex.Unlock()
+
+	if old != nil {
+		old.current.release()
+	}
+	return nil
+}
+
+// Reload opens the index served under the name again, and swaps it in. Searches
+// already running finish on the old index, which is closed after them.
+func (s *Server) Reload(name string) error {
+	s.reload.Lock()
+	defer s.reload.Unlock()
+
+	s.mutex.RLock()
+	e := s.indices[name]
+	s.mutex.RUnlock()
+	if e == nil {
+		return fmt.Errorf("no index named %q", name)
+	}
+
+	index, err := e.open()
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	old := e.current
+	e.current = &handle{index: index, refs: 1}
+	s.mutex.Unlock()
+
+	old.release()
+	log.Printf("Reloaded index %s", name)
+	return nil
+}
+
+// acquire returns the current index served under the name, which must be
+// released after use.
+func (s *Server) acquire(name string) *handle {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	e := s.indices[name]
+	if e == nil {
+		return nil
+	}
+	e.current.acquire()
+	return e.current
+}
+
+// Names returns the names of the indices, sorted.
+func (s *Server) Names() []string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var names []string
+	for name := range s.indices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ListenAndServe serves HTTP requests on the address until Shutdown is called.
+func (s *Server) ListenAndServe(addr string) error {
+	s.mutex.Lock()
+	s.http = &http.Server{
+		Addr:    addr,
+		Handler: s,
+	}
+	srv := s.http
+	s.mutex.Unlock()
+
+	err := srv.ListenAndServe()
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// Shutdown stops accepting requests, waits for those in progress to finish or
+// for the context to be done, and then closes all of the indices.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mutex.Lock()
+	srv := s.http
+	s.mutex.Unlock()
+
+	var err error
+	if srv != nil {
+		err = srv.Shutdown(ctx)
+	}
+
+	s.reload.Lock()
+	defer s.reload.Unlock()
+	s.mutex.Lock()
+	indices := s.indices
+	s.indices = make(map[string]*entry)
+	s.mutex.Unlock()
+
+	for _, e := range indices {
+		e.current.release()
+	}
+	return err
+}
@@ -0,0 +1,185 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/smhanov/nnsearch"
+)
+
+type closeCounter struct {
+	nnsearch.SpaceIndex
+	closed *int
+}
+
+func (cc *closeCounter) Close() error {
+	*cc.closed++
+	return nil
+}
+
+func TestServer(t *testing.T) {
+	vectors := make([][]float32, 1000)
+	for i := range vectors {
+		vectors[i] = make([]float32, 4)
+		for j := range vectors[i] {
+			vectors[i][j] = rand.Float32()
+		}
+	}
+	space := nnsearch.NewVectorSpace(vectors, nnsearch.EuclideanDistance)
+
+	dir, err := ioutil.TempDir("", "server")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	graphFile := filepath.Join(dir, "graph.dat")
+	vectorsFile := filepath.Join(dir, "vectors.dat")
+	nnsearch.NewGraphIndexWithOptions(space, &nnsearch.GraphOptions{K: 10}).Save(graphFile)
+	f, err := os.Create(vectorsFile)
+	if err != nil {
+		panic(err)
+	}
+	bw := bufio.NewWriter(f)
+	nnsearch.FreezeVectors(bw, space)
+	bw.Flush()
+	f.Close()
+
+	s := New()
+	err = s.Load("test", OpenGraph(graphFile, vectorsFile, nnsearch.EuclideanDistance))
+	if err != nil {
+		panic(err)
+	}
+
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	post := func(path string, body interface{}) *searchResponse {
+		data, _ := json.Marshal(body)
+		resp, err := http.Post(ts.URL+path, "application/json", bytes.NewReader(data))
+		if err != nil {
+			panic(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			log.Panicf("%s returned %s", path, resp.Status)
+		}
+		var result searchResponse
+		json.NewDecoder(resp.Body).Decode(&result)
+		return &result
+	}
+
+	// a wide epsilon makes the searches exact on this small graph
+	knn := post("/indices/test/knn", &searchRequest{Vector: vectors[7], K: 5, Epsilon: 2})
+	if len(knn.Results) != 5 || knn.Results[0].Index != 7 {
+		log.Panicf("Unexpected knn results %v", knn.Results)
+	}
+
+	radius := knn.Results[4].Distance
+	within := post("/indices/test/range", &searchRequest{Vector: vectors[7], Radius: radius, Epsilon: 2})
+	if len(within.Results) != 5 {
+		log.Panicf("Expected 5 results within %v, got %v", radius, within.Results)
+	}
+
+	resp, err := http.Get(ts.URL + "/indices/test/neighbours/7?k=4&epsilon=2")
+	if err != nil {
+		panic(err)
+	}
+	var neighbours searchResponse
+	json.NewDecoder(resp.Body).Decode(&neighbours)
+	resp.Body.Close()
+	if len(neighbours.Results) != 4 || neighbours.Results[0].Index != knn.Results[1].Index {
+		log.Panicf("Unexpected neighbours %v", neighbours.Results)
+	}
+
+	resp, err = http.Post(ts.URL+"/indices/missing/knn", "application/json", bytes.NewReader([]byte("{}")))
+	if err != nil {
+		panic(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		log.Panicf("Expected not found, got %s", resp.Status)
+	}
+
+	// searches must use an allowed method
+	req, _ := http.NewRequest(http.MethodPut, ts.URL+"/indices/test/knn", bytes.NewReader([]byte("{}")))
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		panic(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed || resp.Header.Get("Allow") != "GET, POST" {
+		log.Panicf("Expected method not allowed, got %s", resp.Status)
+	}
+
+	resp, err = http.Get(ts.URL + "/indices/test/reload")
+	if err != nil {
+		panic(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		log.Panicf("Expected method not allowed, got %s", resp.Status)
+	}
+
+	resp, err = http.Post(ts.URL+"/indices/test/neighbours/3", "application/json", bytes.NewReader([]byte("{}")))
+	if err != nil {
+		panic(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed || resp.Header.Get("Allow") != "GET" {
+		log.Panicf("Expected method not allowed, got %s", resp.Status)
+	}
+
+	// a reloaded index is closed only after the searches using it finish
+	closed := 0
+	opener := func() (nnsearch.SpaceIndex, error) {
+		return &closeCounter{nnsearch.NewBruteForceIndex(space), &closed}, nil
+	}
+	err = s.Load("swap", opener)
+	if err != nil {
+		panic(err)
+	}
+
+	// the point is left out of its neighbours in indices of plain vectors
+	resp, err = http.Get(ts.URL + "/indices/swap/neighbours/7?k=4")
+	if err != nil {
+		panic(err)
+	}
+	neighbours = searchResponse{}
+	json.NewDecoder(resp.Body).Decode(&neighbours)
+	resp.Body.Close()
+	if len(neighbours.Results) != 4 || neighbours.Results[0].Index != knn.Results[1].Index {
+		log.Panicf("Unexpected neighbours %v", neighbours.Results)
+	}
+
+	h := s.acquire("swap")
+	err = s.Reload("swap")
+	if err != nil {
+		panic(err)
+	}
+	if closed != 0 {
+		log.Panicf("Index closed while in use")
+	}
+	h.release()
+	if closed != 1 {
+		log.Panicf("Index not closed after use")
+	}
+
+	err = s.Shutdown(context.Background())
+	if err != nil {
+		panic(err)
+	}
+	if closed != 2 || len(s.Names()) != 0 {
+		log.Panicf("Indices not closed on shutdown")
+	}
+}
@@ -0,0 +1,162 @@
+package nnsearch
+
+import "math"
+
+// sinkhorn approximates the cheapest transport of the weights of the first
+// document to those of the second by alternately adjusting the potentials of
+// the rows and columns of exp(-distance/regularization) to match the weights.
+// The iterations are done on the logarithms, so that small regularizations do
+// not underflow.
+//
+// The plan is then rounded to one that moves exactly the weights, as the
+// iterations may stop before converging. It returns the cost of this plan,
+// without the entropy term, which is never below the exact distance, and the
+// flows if asked for.
+func sinkhorn(weights1, weights2 []float64, dm [][]float64, opt *WmdOptions, withFlow bool) (float64, [][]float64) {
+	n, m := len(weights1), len(weights2)
+	eps := opt.Regularization
+
+	// the costs and potentials, scaled by 1/eps
+	cost := make([][]float64, n)
+	for i := range cost {
+		cost[i] = make([]float64, m)
+		for j := range cost[i] {
+			cost[i][j] = dm[i][j] / eps
+		}
+	}
+	f := make([]float64, n)
+	g := make([]float64, m)
+
+	terms := make([]float64, n)
+	if m > n {
+		terms = make([]float64, m)
+	}
+
+	for iteration := 0; iteration < opt.MaxIterations; iteration++ {
+		for i := range f {
+			for j := range g {
+				terms[j] = g[j] - cost[i][j]
+			}
+			f[i] = logWeight(weights1[i], logSumExp(terms[:m]))
+		}
+
+		// the rows match exactly after updating f, so the error is in how much
+		// reaches each word of the second document
+		var change float64
+		for j := range g {
+			for i := range f {
+				terms[i] = f[i] - cost[i][j]
+			}
+			lse := logSumExp(terms[:n])
+			change = math.Max(change, math.Abs(math.Exp(g[j]+lse)-weights2[j]))
+			g[j] = logWeight(weights2[j], lse)
+		}
+		if change < opt.Tolerance {
+			break
+		}
+	}
+
+	flow := make([][]float64, n)
+	for i := range flow {
+		flow[i] = make([]float64, m)
+		for j := range flow[i] {
+			flow[i][j] = math.Exp(f[i] + g[j] - cost[i][j])
+		}
+	}
+	roundPlan(flow, weights1, weights2)
+
+	var total float64
+	for i := range flow {
+		for j, x := range flow[i] {
+			total += x * dm[i][j]
+		}
+	}
+
+	if !withFlow {
+		flow = nil
+	}
+	return total, flow
+}
+
+// roundPlan scales down the rows and then the columns of a transport plan
+// that move more than their weight, and spreads what is left to move in
+// proportion to the shortfall of each row and column, as in Altschuler et al.
+// (2017). The rows and columns of the result sum to the weights.
+func roundPlan(flow [][]float64, weights1, weights2 []float64) {
+	n, m := len(weights1), len(weights2)
+	for i := range flow {
+		var sum float64
+		for _, x := range flow[i] {
+			sum += x
+		}
+		if sum > weights1[i] {
+			for j := range flow[i] {
+				flow[i][j] *= weights1[i] / sum
+			}
+		}
+	}
+
+	cols := make([]float64, m)
+	for i := range flow {
+		for j, x := range flow[i] {
+			cols[j] += x
+		}
+	}
+	for j := range cols {
+		if cols[j] > weights2[j] {
+			for i := range flow {
+				flow[i][j] *= weights2[j] / cols[j]
+			}
+		}
+	}
+
+	rowShort := make([]float64, n)
+	colShort := make([]float64, m)
+	var total float64
+	for i := range flow {
+		rowShort[i] = weights1[i]
+		for j, x := range flow[i] {
+			rowShort[i] -= x
+			colShort[j] += x
+		}
+		total += rowShort[i]
+	}
+	for j := range colShort {
+		colShort[j] = weights2[j] - colShort[j]
+	}
+
+	if total <= 0 {
+		return
+	}
+	for i := range flow {
+		for j := range flow[i] {
+			flow[i][j] += rowShort[i] * colShort[j] / total
+		}
+	}
+}
+
+// logWeight returns the potential that scales a row or column whose entries
+// have the given logSumExp to the weight. Words without weight move nothing.
+func logWeight(weight, lse float64) float64 {
+	if weight <= 0 {
+		return math.Inf(-1)
+	}
+	return math.Log(weight) - lse
+}
+
+// logSumExp returns log(sum(exp(x))) without overflowing or underflowing.
+func logSumExp(x []float64) float64 {
+	max := math.Inf(-1)
+	for _, v := range x {
+		max = math.Max(max, v)
+	}
+	if math.IsInf(max, -1) {
+		return max
+	}
+
+	var sum float64
+	for _, v := range x {
+		sum += math.Exp(v - max)
+	}
+	return max + math.Log(sum)
+}
@@ -0,0 +1,281 @@
+package nnsearch
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+
+	"golang.org/x/exp/mmap"
+)
+
+const (
+	fastTextMagic   = 793712314
+	fastTextVersion = 12
+)
+
+// Subwords computes word vectors from a fastText .bin model, which stores a
+// vector for each word of its dictionary and a table of hashed character
+// n-gram buckets. Words missing from the dictionary get the average vector of
+// their n-grams. The table of vectors is mapped from the file rather than
+// read into memory.
+type Subwords struct {
+	dim      int
+	minn     int
+	maxn     int
+	bucket   int
+	nwords   int
+	words    map[string]int
+	pruneidx map[int32]int32
+	pruned   bool
+	file     *mmap.ReaderAt
+	matrix   int64
+	rows     int64
+}
+
+// countingReader counts the bytes read, giving the offset of the vectors in
+// the file.
+type countingReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(b []byte) (int, error) {
+	n, err := cr.r.Read(b)
+	cr.n += int64(n)
+	return n, err
+}
+
+func (cr *countingReader) ReadByte() (byte, error) {
+	b, err := cr.r.ReadByte()
+	if err == nil {
+		cr.n++
+	}
+	return b, err
+}
+
+// OpenFastText opens a fastText .bin model. Quantized (.ftz) models are not
+// supported.
+func OpenFastText(filename string) (*Subwords, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s, err := readFastTextHeader(&countingReader{r: bufio.NewReaderSize(f, 1<<16)})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+
+	s.file, err = mmap.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	if need := s.matrix + 4*s.rows*int64(s.dim); int64(s.file.Len()) < need {
+		s.file.Close()
+		return nil, fmt.Errorf("%s: truncated, expected at least %d bytes", filename, need)
+	}
+	return s, nil
+}
+
+func readFastTextHeader(r *countingReader) (*Subwords, error) {
+	var header [2]int32
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return nil, err
+	}
+	if header[0] != fastTextMagic {
+		return nil, fmt.Errorf("not a fastText model")
+	}
+	if header[1] != fastTextVersion {
+		return nil, fmt.Errorf("unsupported fastText version %d", header[1])
+	}
+
+	// dim, ws, epoch, minCount, neg, wordNgrams, loss, model, bucket, minn,
+	// maxn, lrUpdateRate, then the sampling threshold
+	var args [12]int32
+	var threshold float64
+	if err := binary.Read(r, binary.LittleEndian, &args); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &threshold); err != nil {
+		return nil, err
+	}
+
+	s := &Subwords{
+		dim:    int(args[0]),
+		bucket: int(args[8]),
+		minn:   int(args[9]),
+		maxn:   int(args[10]),
+		words:  make(map[string]int),
+	}
+
+	var dict struct {
+		Size, Words, Labels int32
+		Tokens, PruneSize   int64
+	}
+	if err := binary.Read(r, binary.LittleEndian, &dict); err != nil {
+		return nil, err
+	}
+	s.nwords = int(dict.Words)
+
+	var word []byte
+	for i := 0; i < int(dict.Size); i++ {
+		word = word[:0]
+		for {
+			b, err := r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			if b == 0 {
+				break
+			}
+			word = append(word, b)
+		}
+
+		var entry struct {
+			Count int64
+			Type  int8
+		}
+		if err := binary.Read(r, binary.LittleEndian, &entry); err != nil {
+			return nil, err
+		}
+
+		// labels come after the words and have no vectors
+		if entry.Type == 0 {
+			s.words[string(word)] = i
+		}
+	}
+
+	if dict.PruneSize == 0 {
+		s.maxn = 0
+	} else if dict.PruneSize > 0 {
+		s.pruned = true
+		s.pruneidx = make(map[int32]int32, dict.PruneSize)
+		for i := int64(0); i < dict.PruneSize; i++ {
+			var pair [2]int32
+			if err := binary.Read(r, binary.LittleEndian, &pair); err != nil {
+				return nil, err
+			}
+			s.pruneidx[pair[0]] = pair[1]
+		}
+	}
+
+	var quantized uint8
+	if err := binary.Read(r, binary.LittleEndian, &quantized); err != nil {
+		return nil, err
+	}
+	if quantized != 0 {
+		return nil, fmt.Errorf("quantized models are not supported")
+	}
+
+	var shape [2]int64
+	if err := binary.Read(r, binary.LittleEndian, &shape); err != nil {
+		return nil, err
+	}
+	if shape[1] != int64(s.dim) {
+		return nil, fmt.Errorf("vectors have %d dimensions, expected %d", shape[1], s.dim)
+	}
+	if !s.pruned && shape[0] < int64(s.nwords+s.bucket) {
+		return nil, fmt.Errorf("%d vectors for %d words and %d buckets", shape[0], s.nwords, s.bucket)
+	}
+	s.rows = shape[0]
+
+	s.matrix = r.n
+	return s, nil
+}
+
+// Dims returns the number of dimensions of the vectors.
+func (s *Subwords) Dims() int {
+	return s.dim
+}
+
+// Close unmaps the model.
+func (s *Subwords) Close() error {
+	return s.file.Close()
+}
+
+// fastTextHash is the FNV-1a hash used by fastText, which sign extends each
+// byte.
+func fastTextHash(s string) uint32 {
+	h := uint32(2166136261)
+	for i := 0; i < len(s); i++ {
+		h ^= uint32(int32(int8(s[i])))
+		h *= 16777619
+	}
+	return h
+}
+
+// ngrams returns the rows of the character n-grams of a word, counting
+// UTF-8 sequences as single characters.
+func (s *Subwords) ngrams(word string) []int {
+	word = "<" + word + ">"
+	var rows []int
+	for i := 0; i < len(word); i++ {
+		if word[i]&0xC0 == 0x80 {
+			continue
+		}
+
+		j := i
+		for n := 1; j < len(word) && n <= s.maxn; n++ {
+			j++
+			for j < len(word) && word[j]&0xC0 == 0x80 {
+				j++
+			}
+
+			if n < s.minn || (n == 1 && (i == 0 || j == len(word))) {
+				continue
+			}
+
+			id := int32(fastTextHash(word[i:j]) % uint32(s.bucket))
+			if s.pruned {
+				var ok bool
+				if id, ok = s.pruneidx[id]; !ok {
+					continue
+				}
+			}
+			rows = append(rows, s.nwords+int(id))
+		}
+	}
+	return rows
+}
+
+func (s *Subwords) addRow(total []float32, row int) {
+	b := make([]byte, 4*s.dim)
+	_, err := s.file.ReadAt(b, s.matrix+int64(row)*int64(len(b)))
+	if err != nil && err != io.EOF {
+		log.Panic(err)
+	}
+
+	for i := range total {
+		total[i] += math.Float32frombits(binary.LittleEndian.Uint32(b[4*i:]))
+	}
+}
+
+// Vector returns the vector fastText gives a word: the average of the vector
+// of the word, if it is in the dictionary, and those of its n-grams. It
+// returns nil for an unknown word with no n-grams.
+func (s *Subwords) Vector(word string) []float32 {
+	rows := s.ngrams(word)
+	if id, ok := s.words[word]; ok {
+		rows = append(rows, id)
+	}
+
+	if len(rows) == 0 {
+		return nil
+	}
+
+	total := make([]float32, s.dim)
+	for _, row := range rows {
+		s.addRow(total, row)
+	}
+
+	for i := range total {
+		total[i] /= float32(len(rows))
+	}
+	return total
+}
@@ -0,0 +1,84 @@
+package nnsearch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/fnv"
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+)
+
+func TestSubwords(t *testing.T) {
+	const dim, bucket = 2, 16
+	words := []string{"hello", "world"}
+
+	// a model with n-grams of 3 characters, where the vector of bucket b is
+	// (b, 1) and those of the words are (-1, -1)
+	var buf bytes.Buffer
+	put := func(v interface{}) {
+		if err := binary.Write(&buf, binary.LittleEndian, v); err != nil {
+			panic(err)
+		}
+	}
+	put([2]int32{793712314, 12})
+	put([12]int32{dim, 5, 5, 1, 5, 1, 2, 2, bucket, 3, 3, 100})
+	put(float64(1e-4))
+	put([3]int32{2, 2, 0})
+	put([2]int64{100, -1})
+	for _, word := range words {
+		buf.WriteString(word)
+		buf.WriteByte(0)
+		put(int64(50))
+		put(int8(0))
+	}
+	put(uint8(0))
+	put([2]int64{int64(len(words) + bucket), dim})
+	for range words {
+		put([]float32{-1, -1})
+	}
+	for b := 0; b < bucket; b++ {
+		put([]float32{float32(b), 1})
+	}
+
+	err := ioutil.WriteFile("subwords.bin", buf.Bytes(), 0644)
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove("subwords.bin")
+
+	s, err := OpenFastText("subwords.bin")
+	if err != nil {
+		panic(err)
+	}
+	defer s.Close()
+
+	err = ioutil.WriteFile("subwords.txt", []byte("hello 1 0\nworld 0 1\n"), 0644)
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove("subwords.txt")
+	defer os.Remove("subwords.vecs")
+
+	wv, err := OpenAnyWordVecs("subwords.txt", "subwords.vecs")
+	if err != nil {
+		panic(err)
+	}
+	wv.SetSubwords(s)
+
+	if vec, synthesized := wv.Lookup("hello"); synthesized || vec[0] != 1 {
+		log.Panicf("Expected the stored vector of a known word, got %v", vec)
+	}
+
+	vec, synthesized := wv.Lookup("helo")
+	var sum float32
+	for _, ngram := range []string{"<he", "hel", "elo", "lo>"} {
+		h := fnv.New32a()
+		h.Write([]byte(ngram))
+		sum += float32(h.Sum32() % bucket)
+	}
+	if !synthesized || vec[0] != sum/4 || vec[1] != 1 {
+		log.Panicf("Synthesized %v for an unknown word, expected [%v 1]", vec, sum/4)
+	}
+}
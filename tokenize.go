@@ -0,0 +1,107 @@
+package nnsearch
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Tokenizer splits text into the words looked up in the vectors.
+type Tokenizer interface {
+	Tokenize(text string) []string
+}
+
+// TokenizerFunc makes a function into a Tokenizer.
+type TokenizerFunc func(text string) []string
+
+func (fn TokenizerFunc) Tokenize(text string) []string {
+	return fn(text)
+}
+
+// UnicodeTokenizer splits text into words of letters, digits and marks in
+// any script. Other characters separate words, except for apostrophes and
+// hyphens inside a word, as in "don't" and "e-mail". The zero value lowercases
+// the words and keeps stopwords.
+type UnicodeTokenizer struct {
+	// Keep the case of the words.
+	KeepCase bool
+
+	// Words to leave out, matched after lowercasing.
+	Stopwords map[string]bool
+}
+
+// NewUnicodeTokenizer returns a tokenizer that lowercases words and leaves out
+// the given stopwords.
+func NewUnicodeTokenizer(stopwords []string) *UnicodeTokenizer {
+	t := &UnicodeTokenizer{Stopwords: make(map[string]bool)}
+	for _, word := range stopwords {
+		t.Stopwords[strings.ToLower(word)] = true
+	}
+	return t
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r)
+}
+
+func isJoiner(r rune) bool {
+	return r == '\'' || r == '’' || r == '-'
+}
+
+func (t *UnicodeTokenizer) Tokenize(text string) []string {
+	var tokens []string
+	start := -1
+	for i, r := range text {
+		switch {
+		case isWordRune(r):
+			if start < 0 {
+				start = i
+			}
+		case start >= 0 && isJoiner(r) && followedByWord(text[i+utf8.RuneLen(r):]):
+			// the joiner is inside a word
+		case start >= 0:
+			tokens = t.add(tokens, text[start:i])
+			start = -1
+		}
+	}
+
+	if start >= 0 {
+		tokens = t.add(tokens, text[start:])
+	}
+	return tokens
+}
+
+func followedByWord(text string) bool {
+	r, _ := utf8.DecodeRuneInString(text)
+	return isWordRune(r)
+}
+
+func (t *UnicodeTokenizer) add(tokens []string, word string) []string {
+	lower := strings.ToLower(word)
+	if t.Stopwords[lower] {
+		return tokens
+	}
+
+	if t.KeepCase {
+		return append(tokens, word)
+	}
+	return append(tokens, lower)
+}
+
+// EnglishStopwords is a list of common English function words.
+var EnglishStopwords = []string{
+	"a", "about", "above", "after", "again", "against", "all", "am", "an",
+	"and", "any", "are", "as", "at", "be", "because", "been", "before",
+	"being", "below", "between", "both", "but", "by", "can", "could", "did",
+	"do", "does", "doing", "down", "during", "each", "few", "for", "from",
+	"further", "had", "has", "have", "having", "he", "her", "here", "hers",
+	"herself", "him", "himself", "his", "how", "i", "if", "in", "into", "is",
+	"it", "its", "itself", "just", "me", "more", "most", "my", "myself", "no",
+	"nor", "not", "now", "of", "off", "on", "once", "only", "or", "other",
+	"our", "ours", "ourselves", "out", "over", "own", "same", "she", "should",
+	"so", "some", "such", "than", "that", "the", "their", "theirs", "them",
+	"themselves", "then", "there", "these", "they", "this", "those", "through",
+	"to", "too", "under", "until", "up", "very", "was", "we", "were", "what",
+	"when", "where", "which", "while", "who", "whom", "why", "will", "with",
+	"would", "you", "your", "yours", "yourself", "yourselves",
+}
@@ -0,0 +1,282 @@
+package nnsearch
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// ReductionMethod is a way of reducing the dimensions of vectors.
+type ReductionMethod int
+
+const (
+	// Project on the principal components of a sample of the vectors.
+	PCA ReductionMethod = iota
+
+	// Project on random gaussian directions, which needs no training and
+	// roughly preserves distances.
+	RandomProjection
+)
+
+type TransformOptions struct {
+	// Subtract the mean vector. PCA always does.
+	Center bool
+
+	// Scale the vectors to unit length, after any other changes, so that
+	// euclidean distance orders them as cosine distance does.
+	Normalize bool
+
+	// The number of dimensions to reduce to. Defaults to keeping them all.
+	Dims int
+
+	Method ReductionMethod
+
+	// The number of vectors sampled to find the mean and the principal
+	// components. Defaults to 100000.
+	Sample int
+
+	// Seed of the sample and of the random projection.
+	Seed int64
+}
+
+func getTransformOptions(in *TransformOptions) *TransformOptions {
+	var out TransformOptions
+	if in != nil {
+		out = *in
+	}
+
+	if out.Sample == 0 {
+		out.Sample = 100000
+	}
+	return &out
+}
+
+// VectorTransform maps vectors to new ones, to be applied both to the vectors
+// of a space and to the queries searched in it.
+type VectorTransform struct {
+	// Subtracted from the vectors if not nil.
+	Mean []float32
+
+	// Rows of the directions the vectors are projected on, or nil to keep
+	// the dimensions.
+	Projection [][]float32
+
+	Normalize bool
+}
+
+// FitTransform finds the transform described by the options for the vectors.
+func FitTransform(wv *WordVecs, options *TransformOptions) (*VectorTransform, error) {
+	opt := getTransformOptions(options)
+	if opt.Dims < 0 || opt.Dims > wv.d {
+		return nil, fmt.Errorf("cannot reduce %d dimensions to %d", wv.d, opt.Dims)
+	}
+
+	t := &VectorTransform{Normalize: opt.Normalize}
+	reduce := opt.Dims > 0 && opt.Dims < wv.d
+	r := rand.New(rand.NewSource(opt.Seed))
+
+	var sample [][]float32
+	if opt.Center || (reduce && opt.Method == PCA) {
+		for _, i := range r.Perm(wv.Length()) {
+			if len(sample) == opt.Sample {
+				break
+			}
+			sample = append(sample, wv.Get(wv.all[i]))
+		}
+		if len(sample) == 0 {
+			return nil, fmt.Errorf("no vectors to sample")
+		}
+
+		mean := make([]float64, wv.d)
+		for _, vec := range sample {
+			for i, x := range vec {
+				mean[i] += float64(x)
+			}
+		}
+		t.Mean = make([]float32, wv.d)
+		for i := range mean {
+			t.Mean[i] = float32(mean[i] / float64(len(sample)))
+		}
+	}
+
+	if !reduce {
+		return t, nil
+	}
+
+	switch opt.Method {
+	case PCA:
+		t.Projection = principalComponents(sample, t.Mean, opt.Dims)
+	case RandomProjection:
+		scale := 1 / math.Sqrt(float64(opt.Dims))
+		t.Projection = make([][]float32, opt.Dims)
+		for i := range t.Projection {
+			t.Projection[i] = make([]float32, wv.d)
+			for j := range t.Projection[i] {
+				t.Projection[i][j] = float32(r.NormFloat64() * scale)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown reduction method %d", opt.Method)
+	}
+	return t, nil
+}
+
+// principalComponents returns the k eigenvectors of the covariance of the
+// sample with the largest eigenvalues.
+func principalComponents(sample [][]float32, mean []float32, k int) [][]float32 {
+	d := len(mean)
+	cov := make([][]float64, d)
+	for i := range cov {
+		cov[i] = make([]float64, d)
+	}
+
+	// rows are summed in parallel into separate parts of the matrix
+	ForkLoop(d, func(i int) {
+		for _, vec := range sample {
+			xi := float64(vec[i] - mean[i])
+			for j := i; j < d; j++ {
+				cov[i][j] += xi * float64(vec[j]-mean[j])
+			}
+		}
+	})
+	for i := range cov {
+		for j := i; j < d; j++ {
+			cov[i][j] /= float64(len(sample))
+			cov[j][i] = cov[i][j]
+		}
+	}
+
+	values, vectors := symmetricEigen(cov)
+	order := Sequence(d)
+	sort.Slice(order, func(i, j int) bool {
+		return values[order[i]] > values[order[j]]
+	})
+
+	components := make([][]float32, k)
+	for c := range components {
+		components[c] = make([]float32, d)
+		for i := range components[c] {
+			components[c][i] = float32(vectors[i][order[c]])
+		}
+	}
+	return components
+}
+
+// symmetricEigen finds the eigenvalues and eigenvectors of a symmetric matrix
+// by cyclic Jacobi rotations. The eigenvectors are the columns of the second
+// result. The matrix is overwritten.
+func symmetricEigen(a [][]float64) ([]float64, [][]float64) {
+	n := len(a)
+	v := make([][]float64, n)
+	for i := range v {
+		v[i] = make([]float64, n)
+		v[i][i] = 1
+	}
+
+	for sweep := 0; sweep < 100; sweep++ {
+		var off, total float64
+		for i := 0; i < n; i++ {
+			for j := 0; j < n; j++ {
+				total += a[i][j] * a[i][j]
+				if i != j {
+					off += a[i][j] * a[i][j]
+				}
+			}
+		}
+		if off <= 1e-22*total {
+			break
+		}
+
+		for p := 0; p < n-1; p++ {
+			for q := p + 1; q < n; q++ {
+				if a[p][q] == 0 {
+					continue
+				}
+
+				theta := (a[q][q] - a[p][p]) / (2 * a[p][q])
+				t := 1 / (math.Abs(theta) + math.Sqrt(theta*theta+1))
+				if theta < 0 {
+					t = -t
+				}
+				c := 1 / math.Sqrt(t*t+1)
+				s := t * c
+
+				for k := 0; k < n; k++ {
+					akp, akq := a[k][p], a[k][q]
+					a[k][p] = c*akp - s*akq
+					a[k][q] = s*akp + c*akq
+				}
+				for k := 0; k < n; k++ {
+					apk, aqk := a[p][k], a[q][k]
+					a[p][k] = c*apk - s*aqk
+					a[q][k] = s*apk + c*aqk
+				}
+				for k := 0; k < n; k++ {
+					vkp, vkq := v[k][p], v[k][q]
+					v[k][p] = c*vkp - s*vkq
+					v[k][q] = s*vkp + c*vkq
+				}
+			}
+		}
+	}
+
+	values := make([]float64, n)
+	for i := range values {
+		values[i] = a[i][i]
+	}
+	return values, v
+}
+
+// Dims returns the number of dimensions of the transformed vectors, given
+// that of the input.
+func (t *VectorTransform) Dims(d int) int {
+	if t.Projection != nil {
+		return len(t.Projection)
+	}
+	return d
+}
+
+// Apply returns the transformed vector.
+func (t *VectorTransform) Apply(vec []float32) []float32 {
+	out := make([]float32, len(vec))
+	copy(out, vec)
+	if t.Mean != nil {
+		for i := range out {
+			out[i] -= t.Mean[i]
+		}
+	}
+
+	if t.Projection != nil {
+		projected := make([]float32, len(t.Projection))
+		for i, row := range t.Projection {
+			var dot float64
+			for j := range row {
+				dot += float64(row[j]) * float64(out[j])
+			}
+			projected[i] = float32(dot)
+		}
+		out = projected
+	}
+
+	if t.Normalize {
+		out = normalized(out)
+	}
+	return out
+}
+
+// WriteWordVecs writes the transformed vectors of all of the words in the
+// binary layout read by OpenWordVecs, in the order of the input.
+func (t *VectorTransform) WriteWordVecs(wv *WordVecs, w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	dims := t.Dims(wv.d)
+	fmt.Fprintf(bw, "%d %d\n", len(wv.all), dims)
+	for _, word := range wv.all {
+		if err := writeWordVec(bw, word, t.Apply(wv.Get(word)), dims); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
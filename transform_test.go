@@ -0,0 +1,71 @@
+package nnsearch
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+func TestTransform(t *testing.T) {
+	// points on a plane through (5, 5, 5, 5, 5)
+	words := make([]string, 500)
+	vectors := make([][]float32, len(words))
+	for i := range vectors {
+		a, b := rand.Float32(), rand.Float32()
+		words[i] = fmt.Sprintf("w%d", i)
+		vectors[i] = []float32{5 + a, 5 + 2*b, 5 + a - b, 5, 5 + 3*a}
+	}
+
+	f, err := os.Create("transformtest.bin")
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove("transformtest.bin")
+	if err = WriteWordVecs(f, words, vectors); err != nil {
+		panic(err)
+	}
+	f.Close()
+
+	wv := OpenWordVecs("transformtest.bin")
+	defer wv.Close()
+
+	pca, err := FitTransform(wv, &TransformOptions{Dims: 2, Method: PCA})
+	if err != nil {
+		panic(err)
+	}
+
+	var buf bytes.Buffer
+	if err = pca.WriteWordVecs(wv, &buf); err != nil {
+		panic(err)
+	}
+	err = ioutil.WriteFile("transformtest.pca", buf.Bytes(), 0644)
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove("transformtest.pca")
+
+	// two components keep the distances between points on the plane
+	reduced := OpenWordVecs("transformtest.pca")
+	defer reduced.Close()
+	for i := 1; i < 50; i++ {
+		before := EuclideanDistance(wv.Get(words[0]), wv.Get(words[i]))
+		after := EuclideanDistance(reduced.Get(words[0]), reduced.Get(words[i]))
+		if len(reduced.Get(words[i])) != 2 || math.Abs(before-after) > 1e-4 {
+			log.Panicf("PCA changed a distance from %v to %v", before, after)
+		}
+	}
+
+	random, err := FitTransform(wv, &TransformOptions{Dims: 3, Method: RandomProjection, Normalize: true, Center: true})
+	if err != nil {
+		panic(err)
+	}
+	vec := random.Apply(wv.Get(words[7]))
+	if length := math.Sqrt(float64(vec[0]*vec[0] + vec[1]*vec[1] + vec[2]*vec[2])); len(vec) != 3 || math.Abs(length-1) > 1e-5 {
+		log.Panicf("Expected a unit vector of 3 dimensions, got %v", vec)
+	}
+}
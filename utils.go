@@ -93,7 +93,7 @@ func CosineDistance(vec1 []float32, vec2 []float32) float64 {
 }
 
 func ForkLoop(n int, fn func(i int)) {
-	threads := runtime.NumCPU()
+	threads := runtime.GOMAXPROCS(0)
 	var wg sync.WaitGroup
 
 	worker := func(offset int) {
@@ -112,7 +112,7 @@ func ForkLoop(n int, fn func(i int)) {
 }
 
 func BatchedForkLoop(n, batchSize int, fn func(start, end int)) {
-	threads := runtime.NumCPU()
+	threads := runtime.GOMAXPROCS(0)
 	var wg sync.WaitGroup
 
 	worker := func(offset int) {
@@ -136,7 +136,7 @@ func BatchedForkLoop(n, batchSize int, fn func(start, end int)) {
 }
 
 func ForkWhile(fn func() bool) {
-	threads := runtime.NumCPU()
+	threads := runtime.GOMAXPROCS(0)
 	var wg sync.WaitGroup
 
 	worker := func() {
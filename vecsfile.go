@@ -0,0 +1,200 @@
+package nnsearch
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+
+	"golang.org/x/exp/mmap"
+)
+
+// VecsFile is a space over the vectors of an .fvecs or .bvecs file, the
+// formats of the SIFT, GIST and Deep1B benchmarks. Each vector is stored as a
+// little endian 32 bit dimension followed by its components, which are 32 bit
+// floats in .fvecs files and bytes in .bvecs files. Vectors are read from a
+// memory map when they are needed.
+type VecsFile struct {
+	file     *mmap.ReaderAt
+	n        int
+	dims     int
+	elemSize int
+	distance VectorDistance
+}
+
+// OpenFvecs opens an .fvecs file as a space of DenseVector points compared
+// using the distance function.
+func OpenFvecs(filename string, distance VectorDistance) (*VecsFile, error) {
+	return openVecs(filename, 4, distance)
+}
+
+// OpenBvecs opens a .bvecs file as a space of DenseVector points compared
+// using the distance function. The components are converted to float32.
+func OpenBvecs(filename string, distance VectorDistance) (*VecsFile, error) {
+	return openVecs(filename, 1, distance)
+}
+
+func openVecs(filename string, elemSize int, distance VectorDistance) (*VecsFile, error) {
+	file, err := mmap.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	vf := &VecsFile{
+		file:     file,
+		elemSize: elemSize,
+		distance: distance,
+	}
+
+	if file.Len() == 0 {
+		return vf, nil
+	}
+
+	header := make([]byte, 4)
+	if _, err = file.ReadAt(header, 0); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	vf.dims = int(binary.LittleEndian.Uint32(header))
+	record := vf.recordSize()
+	if vf.dims <= 0 || file.Len()%record != 0 {
+		file.Close()
+		return nil, fmt.Errorf("%s is not a vecs file with %d byte components", filename, elemSize)
+	}
+
+	vf.n = file.Len() / record
+	log.Printf("Read %v vectors of %v dimensions", vf.n, vf.dims)
+	return vf, nil
+}
+
+func (vf *VecsFile) recordSize() int {
+	return 4 + vf.dims*vf.elemSize
+}
+
+// Dims returns the number of dimensions of the vectors.
+func (vf *VecsFile) Dims() int {
+	return vf.dims
+}
+
+func (vf *VecsFile) Length() int {
+	return vf.n
+}
+
+func (vf *VecsFile) At(i int) Point {
+	record := make([]byte, vf.recordSize())
+	_, err := vf.file.ReadAt(record, int64(i*len(record)))
+	if err != nil {
+		log.Panic(err)
+	}
+
+	if d := int(binary.LittleEndian.Uint32(record)); d != vf.dims {
+		log.Panicf("Vector %d has %d dimensions, expected %d", i, d, vf.dims)
+	}
+
+	vec := make([]float32, vf.dims)
+	data := record[4:]
+	for j := range vec {
+		if vf.elemSize == 4 {
+			vec[j] = math.Float32frombits(binary.LittleEndian.Uint32(data[4*j:]))
+		} else {
+			vec[j] = float32(data[j])
+		}
+	}
+
+	return &DenseVector{
+		Index:  i,
+		Vector: vec,
+	}
+}
+
+func (vf *VecsFile) Distance(p1, p2 Point) float64 {
+	return vf.distance(VectorOf(p1), VectorOf(p2))
+}
+
+func (vf *VecsFile) Close() error {
+	return vf.file.Close()
+}
+
+// ReadIvecs reads an .ivecs file, such as the ground truth neighbour lists of
+// the benchmarks. Unlike vectors, the lists may have different lengths.
+func ReadIvecs(filename string) ([][]int, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var lists [][]int
+	for at := 0; at < len(data); {
+		if at+4 > len(data) {
+			return nil, fmt.Errorf("%s: truncated list at byte %d", filename, at)
+		}
+
+		n := int(binary.LittleEndian.Uint32(data[at:]))
+		at += 4
+		if n < 0 || at+4*n > len(data) {
+			return nil, fmt.Errorf("%s: truncated list at byte %d", filename, at-4)
+		}
+
+		list := make([]int, n)
+		for j := range list {
+			list[j] = int(int32(binary.LittleEndian.Uint32(data[at:])))
+			at += 4
+		}
+		lists = append(lists, list)
+	}
+
+	return lists, nil
+}
+
+// WriteFvecs writes the vectors of a space in the .fvecs format.
+func WriteFvecs(w io.Writer, space MetricSpace) error {
+	return writeVecs(w, space, 4)
+}
+
+// WriteBvecs writes the vectors of a space in the .bvecs format. Components
+// are rounded and clamped to the range of a byte.
+func WriteBvecs(w io.Writer, space MetricSpace) error {
+	return writeVecs(w, space, 1)
+}
+
+func writeVecs(w io.Writer, space MetricSpace, elemSize int) error {
+	for i := 0; i < space.Length(); i++ {
+		vec := VectorOf(space.At(i))
+		record := make([]byte, 4+elemSize*len(vec))
+		binary.LittleEndian.PutUint32(record, uint32(len(vec)))
+		for j, x := range vec {
+			if elemSize == 4 {
+				binary.LittleEndian.PutUint32(record[4+4*j:], math.Float32bits(x))
+			} else {
+				record[4+j] = byte(math.Max(0, math.Min(255, math.Round(float64(x)))))
+			}
+		}
+
+		if _, err := w.Write(record); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteIvecs writes lists of integers, such as neighbour lists, in the .ivecs
+// format.
+func WriteIvecs(w io.Writer, lists [][]int) error {
+	for _, list := range lists {
+		record := make([]byte, 4+4*len(list))
+		binary.LittleEndian.PutUint32(record, uint32(len(list)))
+		for j, x := range list {
+			binary.LittleEndian.PutUint32(record[4+4*j:], uint32(int32(x)))
+		}
+
+		if _, err := w.Write(record); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
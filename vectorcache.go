@@ -0,0 +1,133 @@
+package nnsearch
+
+import "sync"
+
+const cacheShards = 32
+
+// The approximate memory used by a cache entry besides its word and vector.
+const cacheEntryOverhead = 64
+
+type cacheEntry struct {
+	word        string
+	vec         []float32
+	synthesized bool
+	referenced  bool
+}
+
+// cacheShard is a part of the cache with its own lock, evicting entries by
+// the CLOCK algorithm: the hand sweeps over the entries, sparing those used
+// since it last passed.
+type cacheShard struct {
+	mutex   sync.Mutex
+	index   map[string]int
+	entries []cacheEntry
+	hand    int
+	bytes   int64
+	budget  int64
+}
+
+// vectorCache caches decoded vectors within a budget of bytes, split over
+// shards so that lookups from many goroutines rarely contend.
+type vectorCache struct {
+	shards [cacheShards]cacheShard
+}
+
+func newVectorCache(budget int64) *vectorCache {
+	c := &vectorCache{}
+	for i := range c.shards {
+		c.shards[i].index = make(map[string]int)
+		c.shards[i].budget = budget / cacheShards
+	}
+	return c
+}
+
+func (c *vectorCache) shard(word string) *cacheShard {
+	h := uint32(2166136261)
+	for i := 0; i < len(word); i++ {
+		h ^= uint32(word[i])
+		h *= 16777619
+	}
+	return &c.shards[h%cacheShards]
+}
+
+func entrySize(word string, vec []float32) int64 {
+	return int64(len(word) + 4*len(vec) + cacheEntryOverhead)
+}
+
+func (c *vectorCache) get(word string) ([]float32, bool, bool) {
+	s := c.shard(word)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	i, ok := s.index[word]
+	if !ok {
+		return nil, false, false
+	}
+	e := &s.entries[i]
+	e.referenced = true
+	return e.vec, e.synthesized, true
+}
+
+func (c *vectorCache) put(word string, vec []float32, synthesized bool) {
+	s := c.shard(word)
+	size := entrySize(word, vec)
+	if size > s.budget {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.index[word]; ok {
+		return
+	}
+
+	for s.bytes+size > s.budget {
+		s.evict()
+	}
+
+	s.index[word] = len(s.entries)
+	s.entries = append(s.entries, cacheEntry{word: word, vec: vec, synthesized: synthesized})
+	s.bytes += size
+}
+
+// evict removes the first entry under the hand that has not been used since
+// the hand last passed it.
+func (s *cacheShard) evict() {
+	for {
+		e := &s.entries[s.hand]
+		if e.referenced {
+			e.referenced = false
+			s.hand = (s.hand + 1) % len(s.entries)
+			continue
+		}
+
+		delete(s.index, e.word)
+		s.bytes -= entrySize(e.word, e.vec)
+
+		last := len(s.entries) - 1
+		if s.hand != last {
+			s.entries[s.hand] = s.entries[last]
+			s.index[s.entries[s.hand].word] = s.hand
+		}
+		s.entries[last] = cacheEntry{}
+		s.entries = s.entries[:last]
+		if s.hand >= len(s.entries) {
+			s.hand = 0
+		}
+		return
+	}
+}
+
+// size returns the number of entries and bytes in the cache.
+func (c *vectorCache) size() (int, int64) {
+	var n int
+	var bytes int64
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.mutex.Lock()
+		n += len(s.entries)
+		bytes += s.bytes
+		s.mutex.Unlock()
+	}
+	return n, bytes
+}
@@ -0,0 +1,57 @@
+package nnsearch
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"testing"
+)
+
+func TestWordVecsCache(t *testing.T) {
+	words := make([]string, 1000)
+	vectors := make([][]float32, len(words))
+	for i := range words {
+		words[i] = fmt.Sprintf("w%d", i)
+		vectors[i] = []float32{float32(i), 1, 2, 3}
+	}
+
+	f, err := os.Create("cachetest.bin")
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove("cachetest.bin")
+	if err = WriteWordVecs(f, words, vectors); err != nil {
+		panic(err)
+	}
+	f.Close()
+
+	budget := int64(cacheShards * 10 * entrySize("w100", vectors[0]))
+	wv := OpenWordVecsWithOptions("cachetest.bin", &WordVecsOptions{CacheBytes: budget})
+	zero := OpenWordVecsWithOptions("cachetest.bin", &WordVecsOptions{ZeroCopy: true})
+	defer wv.Close()
+	if runtime.GOOS == "linux" && littleEndian && zero.data == nil {
+		log.Panicf("Zero copy mode was not used")
+	}
+
+	ForkLoop(4*len(words), func(i int) {
+		word := words[i%len(words)]
+		if wv.Get(word)[0] != vectors[i%len(words)][0] || zero.Get(word)[0] != vectors[i%len(words)][0] {
+			log.Panicf("Wrong vector for %s", word)
+		}
+	})
+
+	if n, bytes := wv.cache.size(); bytes > budget || n == 0 {
+		log.Panicf("Cache holds %d vectors in %d bytes, over the budget of %d", n, bytes, budget)
+	}
+
+	// slices of the mapping stay valid after Close, until it is unmapped
+	vec := zero.Get("w5")
+	zero.Close()
+	if vec[0] != 5 {
+		log.Panicf("Vector changed to %v after closing", vec)
+	}
+	if err = zero.Unmap(); err != nil || zero.data != nil {
+		log.Panicf("Mapping not released: %v", err)
+	}
+}
@@ -0,0 +1,173 @@
+package nnsearch
+
+import (
+	"io"
+	"sort"
+)
+
+// VectorDistance is a distance function between two dense vectors, such as
+// EuclideanDistance or CosineDistance.
+type VectorDistance = func(vec1, vec2 []float32) float64
+
+// VectorPoint is implemented by points that are backed by a dense vector.
+type VectorPoint interface {
+	GetVector() []float32
+}
+
+// DenseVector is a point consisting of a vector and its index in a space.
+type DenseVector struct {
+	Index  int
+	Vector []float32
+}
+
+func (dv *DenseVector) GetVector() []float32 {
+	return dv.Vector
+}
+
+func (wv *WordVector) GetVector() []float32 {
+	return wv.Vector
+}
+
+// VectorOf returns the vector behind a point, or nil if the point is not
+// backed by a vector.
+func VectorOf(pt Point) []float32 {
+	switch v := pt.(type) {
+	case []float32:
+		return v
+	case VectorPoint:
+		return v.GetVector()
+	}
+	return nil
+}
+
+// QueryPreparer is implemented by spaces that compare queries against their
+// points through a precomputed form of the query, such as the lookup tables of
+// a product quantizer. NearestNeighbours prepares the target before searching.
+type QueryPreparer interface {
+	PrepareQuery(target Point) Point
+}
+
+func prepareQuery(space MetricSpace, target Point) Point {
+	if qp, ok := space.(QueryPreparer); ok {
+		return qp.PrepareQuery(target)
+	}
+	return target
+}
+
+// Rerank recomputes the distances of search results using another space,
+// usually one that holds the full precision vectors that a compressed index
+// approximates, and returns the k closest of them.
+func Rerank(space MetricSpace, target Point, results []PointDistance, k int) []PointDistance {
+	reranked := make([]PointDistance, len(results))
+	ForkLoop(len(results), func(i int) {
+		pt := space.At(results[i].Index)
+		reranked[i] = PointDistance{
+			Index:    results[i].Index,
+			Point:    pt,
+			Distance: space.Distance(pt, target),
+		}
+	})
+
+	sort.Slice(reranked, func(a, b int) bool {
+		return reranked[a].Distance < reranked[b].Distance
+	})
+
+	if len(reranked) > k {
+		reranked = reranked[:k]
+	}
+
+	return reranked
+}
+
+type vectorItem []float32
+
+func (v *vectorItem) Encode(w io.Writer) uint64 {
+	return WriteThing(w, []float32(*v))
+}
+
+func (v *vectorItem) Decode(r ByteInputStream) {
+	ReadThing(r, (*[]float32)(v))
+}
+
+// FreezeVectors writes the vectors of all points in the space to a frozen
+// file, so they can be read back with OpenFrozenVectors without keeping them in
+// memory.
+func FreezeVectors(w io.Writer, space MetricSpace) uint64 {
+	items := make([]FrozenItem, space.Length())
+	for i := range items {
+		v := vectorItem(VectorOf(space.At(i)))
+		items[i] = &v
+	}
+	return FreezeItems(w, items)
+}
+
+// FrozenVectors is a metric space over vectors read from a frozen file as
+// they are needed.
+type FrozenVectors struct {
+	ff       *FrozenFile
+	distance VectorDistance
+}
+
+// OpenFrozenVectors opens a file written by FreezeVectors as a metric space
+// whose points are *DenseVector.
+func OpenFrozenVectors(filename string, distance VectorDistance) (*FrozenVectors, error) {
+	ff, err := OpenFrozenFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FrozenVectors{
+		ff:       ff,
+		distance: distance,
+	}, nil
+}
+
+func (fv *FrozenVectors) Length() int {
+	return int(fv.ff.GetCount())
+}
+
+func (fv *FrozenVectors) At(i int) Point {
+	var v vectorItem
+	fv.ff.GetItem(i, &v)
+	return &DenseVector{
+		Index:  i,
+		Vector: v,
+	}
+}
+
+func (fv *FrozenVectors) Distance(p1, p2 Point) float64 {
+	return fv.distance(VectorOf(p1), VectorOf(p2))
+}
+
+func (fv *FrozenVectors) Close() error {
+	return fv.ff.Close()
+}
+
+type vectorSpace struct {
+	vectors  [][]float32
+	distance VectorDistance
+}
+
+// NewVectorSpace returns a metric space over vectors held in memory, whose
+// points are *DenseVector.
+func NewVectorSpace(vectors [][]float32, distance VectorDistance) MetricSpace {
+	return &vectorSpace{
+		vectors:  vectors,
+		distance: distance,
+	}
+}
+
+func (vs *vectorSpace) Length() int {
+	return len(vs.vectors)
+}
+
+func (vs *vectorSpace) At(i int) Point {
+	return &DenseVector{
+		Index:  i,
+		Vector: vs.vectors[i],
+	}
+}
+
+func (vs *vectorSpace) Distance(p1, p2 Point) float64 {
+	return vs.distance(VectorOf(p1), VectorOf(p2))
+}
@@ -0,0 +1,88 @@
+package nnsearch
+
+import (
+	"log"
+	"math"
+	"os"
+	"testing"
+)
+
+func TestWmdBounds(t *testing.T) {
+	defer os.Remove("wmdbounds.bin")
+	wv, docs := topicDocuments("wmdbounds.bin")
+	defer wv.Close()
+
+	calc := NewWordMoverDistanceCalculator(wv)
+	for i := 1; i < 30; i++ {
+		exact := calc.Compute(docs[0].Words, docs[i].Words)
+		if calc.WordCentroidDistance(docs[0].Words, docs[i].Words) > exact+1e-9 ||
+			calc.RelaxedWMD(docs[0].Words, docs[i].Words) > exact+1e-9 {
+			log.Panicf("Lower bounds of document %d exceed the distance %v", i, exact)
+		}
+	}
+}
+
+func TestComputeWeighted(t *testing.T) {
+	defer os.Remove("wmdweighted.bin")
+	wv, docs := topicDocuments("wmdweighted.bin")
+	defer wv.Close()
+
+	// weighted documents count repeated words once with more weight, and
+	// leave out unknown words
+	calc := NewWordMoverDistanceCalculator(wv)
+	a, b := docs[0].Words, docs[3].Words
+	weighted := calc.ComputeWeighted(TermWeights(append(a, a...), nil), TermWeights(append(b, "unknown"), nil))
+	if math.Abs(weighted-calc.Compute(a, b)) > 1e-9 {
+		log.Panicf("Weighted distance %v differs from %v", weighted, calc.Compute(a, b))
+	}
+}
+
+func TestSinkhorn(t *testing.T) {
+	defer os.Remove("wmdsinkhorn.bin")
+	wv, docs := topicDocuments("wmdsinkhorn.bin")
+	defer wv.Close()
+
+	// Sinkhorn comes close to the exact distance, even with a regularization
+	// small enough to underflow exp(-distance/regularization), and its plan
+	// moves the weights of the words, so its cost is never below the exact one
+	calc := NewWordMoverDistanceCalculator(wv)
+	approx := NewWordMoverDistanceCalculatorWithOptions(wv, &WmdOptions{
+		Sinkhorn: true, Regularization: 0.001, MaxIterations: 1000})
+	for i := 1; i < 10; i++ {
+		exact, plan := calc.ComputePlan(docs[0].Words, docs[i].Words)
+		cost, sinkhornPlan := approx.ComputePlan(docs[0].Words, docs[i].Words)
+		if cost < exact-1e-9 || cost > exact+0.005 {
+			log.Panicf("Sinkhorn distance %v is far from %v", cost, exact)
+		}
+
+		for _, p := range []*TransportPlan{plan, sinkhornPlan} {
+			for j := range p.Words2 {
+				var moved float64
+				for i := range p.Words1 {
+					moved += p.Flow[i][j]
+				}
+				if math.Abs(moved-0.25) > 1e-9 {
+					log.Panicf("Plan moves %v to %s, expected 0.25", moved, p.Words2[j])
+				}
+			}
+			for i := range p.Words1 {
+				var moved float64
+				for j := range p.Words2 {
+					moved += p.Flow[i][j]
+				}
+				if math.Abs(moved-0.25) > 1e-9 {
+					log.Panicf("Plan moves %v from %s, expected 0.25", moved, p.Words1[i])
+				}
+			}
+		}
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				log.Panic("Accepted a negative regularization")
+			}
+		}()
+		getWmdOptions(&WmdOptions{Sinkhorn: true, Regularization: -1})
+	}()
+}
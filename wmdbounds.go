@@ -0,0 +1,221 @@
+package nnsearch
+
+import (
+	"container/heap"
+	"io"
+	"math"
+	"runtime"
+	"sort"
+)
+
+// centroid returns the weighted mean of the normalized vectors of the words,
+// and the weight of the words that have no vector.
+func (wc *WmdCalc) centroid(h histogram) ([]float32, float64) {
+	total := make([]float32, wc.wv.d)
+	var missing float64
+	for j, word := range h.words {
+		vec := wc.wv.Get(word)
+		if vec == nil {
+			missing += h.weights[j]
+			continue
+		}
+		for i, x := range normalized(vec) {
+			total[i] += float32(h.weights[j]) * x
+		}
+	}
+	return total, missing
+}
+
+// centroidBound is the word centroid distance given the centroids of two
+// documents. The distance between words is the angle between their vectors
+// divided by pi, which is at least their euclidean distance divided by pi
+// once normalized, so the distance between the centroids bounds the WMD from
+// below. Words without vectors are cutoff away from every word, so when there
+// are any, they give the bound instead.
+func (wc *WmdCalc) centroidBound(c1 []float32, missing1 float64, c2 []float32, missing2 float64) float64 {
+	if missing1 > 0 || missing2 > 0 {
+		return wc.cutoff * math.Max(missing1, missing2)
+	}
+	return EuclideanDistance(c1, c2) / math.Pi
+}
+
+// WordCentroidDistance returns the word centroid distance of two documents, a
+// cheap lower bound of their Word Mover's Distance.
+func (wc *WmdCalc) WordCentroidDistance(words1, words2 []string) float64 {
+	if len(words1) == 0 || len(words2) == 0 {
+		return math.Inf(1)
+	}
+
+	c1, missing1 := wc.centroid(uniformHistogram(words1))
+	c2, missing2 := wc.centroid(uniformHistogram(words2))
+	return wc.centroidBound(c1, missing1, c2, missing2)
+}
+
+// relaxedBound is the relaxed WMD given the distances between the words of two
+// documents: each word moves all of its weight to the closest word of the
+// other document, which is the cheapest transport when only one side's weights
+// have to be matched. The larger of the two directions is returned.
+func relaxedBound(h1, h2 histogram, dm [][]float64) float64 {
+	rows := make([]float64, len(dm))
+	cols := make([]float64, len(dm[0]))
+	for j := range cols {
+		cols[j] = math.Inf(1)
+	}
+
+	for i := range dm {
+		rows[i] = math.Inf(1)
+		for j, d := range dm[i] {
+			rows[i] = math.Min(rows[i], d)
+			cols[j] = math.Min(cols[j], d)
+		}
+	}
+
+	var forward, backward float64
+	for i, d := range rows {
+		forward += h1.weights[i] * d
+	}
+	for j, d := range cols {
+		backward += h2.weights[j] * d
+	}
+	return math.Max(forward, backward)
+}
+
+// RelaxedWMD returns the relaxed Word Mover's Distance of two documents, a
+// lower bound that is tighter than the word centroid distance, but needs the
+// distances between all of their words.
+func (wc *WmdCalc) RelaxedWMD(words1, words2 []string) float64 {
+	if len(words1) == 0 || len(words2) == 0 {
+		return math.Inf(1)
+	}
+	return relaxedBound(uniformHistogram(words1), uniformHistogram(words2), wc.calculateDistanceMatrix(words1, words2))
+}
+
+type documentIndex struct {
+	*DocumentSpace
+	centroids [][]float32
+	missing   []float64
+}
+
+// NewDocumentIndex returns an index of the documents that finds the exact
+// nearest neighbours by Word Mover's Distance, computing it for as few
+// documents as the lower bounds allow.
+func NewDocumentIndex(space *DocumentSpace) SpaceIndex {
+	di := &documentIndex{
+		DocumentSpace: space,
+		centroids:     make([][]float32, space.Length()),
+		missing:       make([]float64, space.Length()),
+	}
+
+	ForkLoop(space.Length(), func(i int) {
+		di.centroids[i], di.missing[i] = space.calc.centroid(di.histograms[i])
+	})
+	return di
+}
+
+func (di *documentIndex) Write(w io.Writer) (int64, error) {
+	return 0, nil
+}
+
+// NearestNeighbours visits the documents in order of their word centroid
+// distance to the target, and stops once that exceeds the distance of the
+// k-th result. The exact distance of a document is only computed when its
+// relaxed WMD could still beat the k-th result. The budget limits the number
+// of exact distances computed, which are counted as visited in the stats.
+func (di *documentIndex) NearestNeighbours(target Point, k int, options *SearchOptions) []PointDistance {
+	opt := getOptions(options)
+	doc := target.(*Document)
+	calc := di.calc
+	query := di.histogram(doc)
+	results := make(pointHeap, 0, k)
+	if len(query.words) == 0 || k <= 0 {
+		return results
+	}
+
+	c, missing := calc.centroid(query)
+	bounds := make([]float64, di.Length())
+	ForkLoop(di.Length(), func(i int) {
+		if len(di.histograms[i].words) == 0 || !opt.Filter(di.docs[i]) {
+			bounds[i] = math.Inf(1)
+			return
+		}
+		bounds[i] = calc.centroidBound(c, missing, di.centroids[i], di.missing[i])
+	})
+
+	order := Sequence(di.Length())
+	sort.Slice(order, func(a, b int) bool {
+		return bounds[order[a]] < bounds[order[b]]
+	})
+
+	// candidates are checked in batches, one for each thread, against the
+	// k-th distance at the start of the batch
+	batchSize := runtime.GOMAXPROCS(0)
+	distances := make([]float64, batchSize)
+	visited := 0
+	for start := 0; start < len(order); start += batchSize {
+		if opt.Ctx.Err() != nil || (opt.Budget > 0 && visited >= opt.Budget) {
+			break
+		}
+
+		kth := math.Inf(1)
+		if len(results) == k {
+			kth = results[0].Distance
+		}
+
+		batch := order[start:]
+		if len(batch) > batchSize {
+			batch = batch[:batchSize]
+		}
+		if opt.Budget > 0 && len(batch) > opt.Budget-visited {
+			batch = batch[:opt.Budget-visited]
+		}
+		for len(batch) > 0 && (bounds[batch[len(batch)-1]] >= kth || math.IsInf(bounds[batch[len(batch)-1]], 1)) {
+			batch = batch[:len(batch)-1]
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		computed := make([]bool, len(batch))
+		ForkLoop(len(batch), func(b int) {
+			if di.docs[batch[b]] == doc {
+				distances[b], computed[b] = 0, true
+				return
+			}
+
+			h := di.histograms[batch[b]]
+			dm := calc.calculateDistanceMatrix(query.words, h.words)
+			if relaxedBound(query, h, dm) >= kth {
+				return
+			}
+			distances[b], _ = calc.solve(query.weights, h.weights, dm, false)
+			computed[b] = true
+		})
+
+		for b, i := range batch {
+			if !computed[b] {
+				continue
+			}
+			visited++
+
+			if len(results) < k || distances[b] < results[0].Distance {
+				if len(results) == k {
+					heap.Pop(&results)
+				}
+				heap.Push(&results, PointDistance{
+					Index:    i,
+					Point:    di.docs[i],
+					Distance: distances[b],
+				})
+			}
+		}
+	}
+
+	if opt.Stats != nil {
+		opt.Stats.Visited += visited
+	}
+
+	sort.Slice(results, func(a, b int) bool {
+		return results[a].Distance < results[b].Distance
+	})
+	return results
+}
@@ -3,6 +3,7 @@ package nnsearch
 import (
 	"log"
 	"math"
+	"sort"
 
 	"github.com/yizha/go/tp"
 )
@@ -10,12 +11,63 @@ import (
 // Word Mover Distance calculator
 
 type WmdCalc struct {
-	wv     *WordVecs
-	cutoff float64 // cutoff, the "average" distance between points beyond which it is not useful to keep neighbours
+	wv      *WordVecs
+	cutoff  float64 // cutoff, the "average" distance between points beyond which it is not useful to keep neighbours
+	options *WmdOptions
+}
+
+type WmdOptions struct {
+	// Approximate the distance by Sinkhorn iterations on the entropy
+	// regularized transport problem instead of solving it exactly. This is
+	// much faster on long documents.
+	Sinkhorn bool
+
+	// The weight of the entropy in the Sinkhorn problem. Smaller values come
+	// closer to the exact distance but converge more slowly. The distance is
+	// that of a plan moving exactly the weights of the words, so it is never
+	// below the exact one. Defaults to 0.05.
+	Regularization float64
+
+	// The maximum number of Sinkhorn iterations. Defaults to 100.
+	MaxIterations int
+
+	// Sinkhorn iterations stop once the weights moved from each word of the
+	// second document are within this of its weight. Defaults to 1e-6.
+	Tolerance float64
+}
+
+func getWmdOptions(in *WmdOptions) *WmdOptions {
+	var out WmdOptions
+	if in != nil {
+		out = *in
+	}
+
+	if out.Regularization == 0 {
+		out.Regularization = 0.05
+	} else if out.Regularization < 0 {
+		log.Panicf("Regularization must be positive, got %v", out.Regularization)
+	}
+
+	if out.MaxIterations == 0 {
+		out.MaxIterations = 100
+	}
+
+	if out.Tolerance == 0 {
+		out.Tolerance = 1e-6
+	}
+	return &out
 }
 
 func NewWordMoverDistanceCalculator(wv *WordVecs) *WmdCalc {
-	return &WmdCalc{wv, ComputeAverageDistance(wv, 1000)}
+	return NewWordMoverDistanceCalculatorWithOptions(wv, nil)
+}
+
+func NewWordMoverDistanceCalculatorWithOptions(wv *WordVecs, options *WmdOptions) *WmdCalc {
+	return &WmdCalc{
+		wv:      wv,
+		cutoff:  ComputeAverageDistance(wv, 1000),
+		options: getWmdOptions(options),
+	}
 }
 
 func (wc *WmdCalc) calculateDistanceMatrix(d1, d2 []string) [][]float64 {
@@ -64,31 +116,149 @@ func printmatrix(words1, words2 []string, mat [][]float64) {
 */
 
 func (wc *WmdCalc) Compute(words1, words2 []string) float64 {
-	if len(words1) == 0 || len(words2) == 0 {
+	return wc.computeHistograms(uniformHistogram(words1), uniformHistogram(words2))
+}
+
+// histogram is a document as words and the weights they carry, which sum to
+// one.
+type histogram struct {
+	words   []string
+	weights []float64
+}
+
+// uniformHistogram gives each token the same weight, so repeated words count
+// several times.
+func uniformHistogram(words []string) histogram {
+	return histogram{words, ones(len(words))}
+}
+
+// weightedHistogram normalizes the weights of the words, leaving out those
+// without vectors or without a positive weight. The words are sorted so the
+// result does not depend on the order of the map.
+func (wc *WmdCalc) weightedHistogram(weights map[string]float64) histogram {
+	var h histogram
+	for word, weight := range weights {
+		if weight > 0 && wc.wv.Get(word) != nil {
+			h.words = append(h.words, word)
+		}
+	}
+	sort.Strings(h.words)
+
+	var total float64
+	h.weights = make([]float64, len(h.words))
+	for i, word := range h.words {
+		h.weights[i] = weights[word]
+		total += weights[word]
+	}
+	for i := range h.weights {
+		h.weights[i] /= total
+	}
+	return h
+}
+
+// histogram returns the words of the document and their weights.
+func (wc *WmdCalc) histogram(d *Document) histogram {
+	if d.Weights != nil {
+		return wc.weightedHistogram(d.Weights)
+	}
+	return uniformHistogram(d.Words)
+}
+
+func (wc *WmdCalc) computeHistograms(h1, h2 histogram) float64 {
+	if len(h1.words) == 0 || len(h2.words) == 0 {
 		return math.Inf(1)
 	}
 
-	//log.Printf("%v=>%v", words1, words2)
+	dm := wc.calculateDistanceMatrix(h1.words, h2.words)
+	cost, _ := wc.solve(h1.weights, h2.weights, dm, false)
+	return cost
+}
+
+// TransportPlan is the weight moved from each word of one document to each
+// word of another by the Word Mover's Distance.
+type TransportPlan struct {
+	Words1 []string
+	Words2 []string
+
+	// Flow[i][j] is the weight moved from Words1[i] to Words2[j].
+	Flow [][]float64
+}
+
+func (wc *WmdCalc) planHistograms(h1, h2 histogram) (float64, *TransportPlan) {
+	if len(h1.words) == 0 || len(h2.words) == 0 {
+		return math.Inf(1), nil
+	}
+
+	dm := wc.calculateDistanceMatrix(h1.words, h2.words)
+	cost, flow := wc.solve(h1.weights, h2.weights, dm, true)
+	return cost, &TransportPlan{
+		Words1: h1.words,
+		Words2: h2.words,
+		Flow:   flow,
+	}
+}
+
+// ComputePlan returns the distance computed by Compute together with the plan
+// that moves the words of the first document to those of the second. The
+// plan is nil if either document is empty.
+func (wc *WmdCalc) ComputePlan(words1, words2 []string) (float64, *TransportPlan) {
+	return wc.planHistograms(uniformHistogram(words1), uniformHistogram(words2))
+}
+
+// ComputeWeightedPlan returns the distance computed by ComputeWeighted
+// together with its transport plan.
+func (wc *WmdCalc) ComputeWeightedPlan(weights1, weights2 map[string]float64) (float64, *TransportPlan) {
+	return wc.planHistograms(wc.weightedHistogram(weights1), wc.weightedHistogram(weights2))
+}
+
+// ComputeWeighted returns the Word Mover's Distance between two documents
+// given as the weights of their distinct words, such as term frequencies or
+// TF-IDF weights. Words missing from the vectors are left out, rather than
+// being placed at the cutoff distance from every word as Compute does.
+func (wc *WmdCalc) ComputeWeighted(weights1, weights2 map[string]float64) float64 {
+	return wc.computeHistograms(wc.weightedHistogram(weights1), wc.weightedHistogram(weights2))
+}
+
+// TermWeights returns the weights of the distinct words of a list of tokens:
+// the number of times each appears multiplied by its weight, such as the IDF
+// from QueryEncoder.Weight. A nil weight gives term frequencies.
+func TermWeights(words []string, weight func(word string) float64) map[string]float64 {
+	weights := make(map[string]float64)
+	for _, word := range words {
+		weights[word]++
+	}
+
+	if weight != nil {
+		for word := range weights {
+			weights[word] *= weight(word)
+		}
+	}
+	return weights
+}
+
+// solve returns the cost of the cheapest transport of the weights of the first
+// document to those of the second, given the distances between their words,
+// and the flows of the transport if asked for.
+func (wc *WmdCalc) solve(weights1, weights2 []float64, dm [][]float64, withFlow bool) (float64, [][]float64) {
+	if wc.options.Sinkhorn {
+		return sinkhorn(weights1, weights2, dm, wc.options, withFlow)
+	}
 
-	dm := wc.calculateDistanceMatrix(words1, words2)
-	p, err := tp.CreateProblem(ones(len(words1)), ones(len(words2)), dm)
+	p, err := tp.CreateProblem(weights1, weights2, dm)
 	if err != nil {
 		log.Panic(err)
-		return math.Inf(1)
+		return math.Inf(1), nil
 	}
 	err = p.Solve()
 	if err != nil {
 		log.Panic(err)
-		return math.Inf(1)
+		return math.Inf(1), nil
 	}
 
-	cost := p.GetCost() /*
-		log.Printf("%v(%v)=>%v(%v): %v", words1, ones(len(words1)), words2, ones(len(words2)), cost)
-		log.Printf("Distances")
-		printmatrix(words1, words2, dm)
-		log.Printf("Flow")
-		printmatrix(words1, words2, p.GetFlow())*/
-	return cost
+	if withFlow {
+		return p.GetCostAndFlow()
+	}
+	return p.GetCost(), nil
 }
 
 func (wc *WmdCalc) Compute_old(words1, words2 []string) float64 {
@@ -0,0 +1,327 @@
+package nnsearch
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// WordVecsFormat is a file format for word vectors.
+type WordVecsFormat int
+
+const (
+	// The binary word2vec layout read by OpenWordVecs: an "n d" header line,
+	// then for each word the word, a space, d little endian float32s and a
+	// newline.
+	Word2VecBinary WordVecsFormat = iota
+
+	// Text with an "n d" header line followed by a word and its d values on
+	// each line, as written by word2vec in text mode and by fastText in .vec
+	// files.
+	Word2VecText
+
+	// Text without a header line, as in the GloVe .txt files.
+	GloVeText
+)
+
+func (f WordVecsFormat) String() string {
+	switch f {
+	case Word2VecBinary:
+		return "word2vec binary"
+	case Word2VecText:
+		return "word2vec text"
+	case GloVeText:
+		return "GloVe text"
+	}
+	return fmt.Sprintf("WordVecsFormat(%d)", int(f))
+}
+
+// The longest line read while detecting the format of a file.
+const maxDetectLine = 1 << 20
+
+// parseHeader parses an "n d" header line.
+func parseHeader(line string) (int, int, bool) {
+	fields := strings.Fields(line)
+	if len(fields) != 2 {
+		return 0, 0, false
+	}
+
+	n, err1 := strconv.Atoi(fields[0])
+	d, err2 := strconv.Atoi(fields[1])
+	if err1 != nil || err2 != nil || n < 0 || d <= 0 {
+		return 0, 0, false
+	}
+	return n, d, true
+}
+
+// parseVectorLine parses a line of a word and its values. If dims is zero it
+// is taken from the line, assuming the word has no spaces. Otherwise the last
+// dims fields are the values and the rest form the word.
+func parseVectorLine(line string, dims int) (string, []float32, error) {
+	fields := strings.Fields(line)
+	if dims == 0 {
+		dims = len(fields) - 1
+	}
+
+	if dims <= 0 || len(fields) < dims+1 {
+		return "", nil, fmt.Errorf("expected a word and %d values, got %d fields", dims, len(fields))
+	}
+
+	word := strings.Join(fields[:len(fields)-dims], " ")
+	vec := make([]float32, dims)
+	for i, s := range fields[len(fields)-dims:] {
+		x, err := strconv.ParseFloat(s, 32)
+		if err != nil {
+			return "", nil, fmt.Errorf("value %d of %q: %v", i+1, word, err)
+		}
+		vec[i] = float32(x)
+	}
+	return word, vec, nil
+}
+
+// readLine reads a line without its line ending, returning io.EOF only when
+// there is nothing left to read.
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+// DetectWordVecsFormat determines the format of a file of word vectors from
+// its first lines.
+func DetectWordVecsFormat(filename string) (WordVecsFormat, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	r := bufio.NewReaderSize(io.LimitReader(file, 2*maxDetectLine), maxDetectLine)
+	first, err := readLine(r)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %v", filename, err)
+	}
+
+	_, d, hasHeader := parseHeader(first)
+	if !hasHeader {
+		if _, _, err := parseVectorLine(first, 0); err != nil {
+			return 0, fmt.Errorf("%s: line 1 is neither a header nor a word vector: %v", filename, err)
+		}
+		return GloVeText, nil
+	}
+
+	// after the header, binary files have raw floats after the first word
+	// where text files have a word, which may contain spaces, then d numbers
+	// and a newline.
+	second, err := readLine(r)
+	if err == io.EOF {
+		return Word2VecText, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("%s: %v", filename, err)
+	}
+
+	if _, _, err := parseVectorLine(second, d); err == nil {
+		return Word2VecText, nil
+	}
+	return Word2VecBinary, nil
+}
+
+// ReadTextWordVecs reads word vectors in a text format, calling fn for each.
+// Malformed lines are reported with their line numbers.
+func ReadTextWordVecs(r io.Reader, format WordVecsFormat, fn func(word string, vec []float32) error) error {
+	br := bufio.NewReaderSize(r, 1<<16)
+	lineNumber := 0
+	count, dims := -1, 0
+
+	if format == Word2VecText {
+		line, err := readLine(br)
+		if err != nil {
+			return fmt.Errorf("line 1: missing header: %v", err)
+		}
+		lineNumber++
+
+		var ok bool
+		count, dims, ok = parseHeader(line)
+		if !ok {
+			return fmt.Errorf("line 1: expected a header of the word count and dimensions, got %q", line)
+		}
+	} else if format != GloVeText {
+		return fmt.Errorf("%v is not a text format", format)
+	}
+
+	words := 0
+	for {
+		line, err := readLine(br)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		lineNumber++
+
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		word, vec, err := parseVectorLine(line, dims)
+		if err != nil {
+			return fmt.Errorf("line %d: %v", lineNumber, err)
+		}
+		dims = len(vec)
+		words++
+
+		if err = fn(word, vec); err != nil {
+			return fmt.Errorf("line %d: %v", lineNumber, err)
+		}
+	}
+
+	if count >= 0 && words != count {
+		return fmt.Errorf("header gives %d words but the file has %d", count, words)
+	}
+	return nil
+}
+
+// WriteWordVecs writes word vectors in the binary layout read by
+// OpenWordVecs.
+func WriteWordVecs(w io.Writer, words []string, vectors [][]float32) error {
+	if len(words) != len(vectors) {
+		return fmt.Errorf("%d words but %d vectors", len(words), len(vectors))
+	}
+
+	dims := 0
+	if len(vectors) > 0 {
+		dims = len(vectors[0])
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "%d %d\n", len(words), dims)
+	for i, word := range words {
+		if err := writeWordVec(bw, word, vectors[i], dims); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+func writeWordVec(w io.Writer, word string, vec []float32, dims int) error {
+	if len(vec) != dims {
+		return fmt.Errorf("vector of %q has %d dimensions, expected %d", word, len(vec), dims)
+	}
+
+	if word == "" || strings.ContainsAny(word, " \n") {
+		return fmt.Errorf("word %q cannot be stored in the binary layout", word)
+	}
+
+	record := make([]byte, len(word)+1+4*dims+1)
+	copy(record, word)
+	record[len(word)] = ' '
+	for j, x := range vec {
+		binary.LittleEndian.PutUint32(record[len(word)+1+4*j:], math.Float32bits(x))
+	}
+	record[len(record)-1] = '\n'
+
+	_, err := w.Write(record)
+	return err
+}
+
+// ConvertWordVecs converts a file of word vectors in a text format to the
+// binary layout read by OpenWordVecs. The input is read twice, first to check
+// it and count the words, so it is never held in memory. Words containing
+// spaces cannot be stored in the binary layout, and are reported with their
+// line numbers.
+func ConvertWordVecs(in, out string) error {
+	format, err := DetectWordVecsFormat(in)
+	if err != nil {
+		return err
+	}
+
+	if format == Word2VecBinary {
+		return fmt.Errorf("%s is already in the binary layout", in)
+	}
+
+	readFile := func(fn func(word string, vec []float32) error) error {
+		file, err := os.Open(in)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		err = ReadTextWordVecs(file, format, fn)
+		if err != nil {
+			return fmt.Errorf("%s: %v", in, err)
+		}
+		return nil
+	}
+
+	count, dims := 0, 0
+	err = readFile(func(word string, vec []float32) error {
+		if count == 0 {
+			dims = len(vec)
+		} else if len(vec) != dims {
+			return fmt.Errorf("vector of %q has %d dimensions, expected %d", word, len(vec), dims)
+		}
+		if strings.Contains(word, " ") {
+			return fmt.Errorf("word %q contains a space, which the binary layout cannot store", word)
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	bw := bufio.NewWriter(file)
+	fmt.Fprintf(bw, "%d %d\n", count, dims)
+	err = readFile(func(word string, vec []float32) error {
+		return writeWordVec(bw, word, vec, dims)
+	})
+	if err != nil {
+		return err
+	}
+
+	return bw.Flush()
+}
+
+// OpenAnyWordVecs opens word vectors in any of the supported formats. Binary
+// files are opened directly. Text files are converted to the binary layout in
+// the file named converted, which is reused by later calls as long as it is
+// newer than the text file.
+func OpenAnyWordVecs(filename, converted string) (*WordVecs, error) {
+	format, err := DetectWordVecsFormat(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	if format == Word2VecBinary {
+		return OpenWordVecs(filename), nil
+	}
+
+	in, err := os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := os.Stat(converted)
+	if err != nil || out.ModTime().Before(in.ModTime()) {
+		err = ConvertWordVecs(filename, converted)
+		if err != nil {
+			os.Remove(converted)
+			return nil, err
+		}
+	}
+
+	return OpenWordVecs(converted), nil
+}
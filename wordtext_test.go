@@ -0,0 +1,82 @@
+package nnsearch
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestTextWordVecs(t *testing.T) {
+	glove := "the 0.5 -1 2\nof 1e-2 3 4\n\nand 7 8 9\n"
+	vec := "3 2\nthe 1 2\nof 3 4\nand 5 6\n"
+	bad := "3 2\nthe 1 2\nof 3\nand 5 6\n"
+	phrases := "3 2\nnew york 1 2\nthe 3 4\nlos angeles 5 6\n"
+
+	files := map[string]string{"glove.txt": glove, "fasttext.vec": vec, "bad.vec": bad, "phrases.vec": phrases}
+	for name, contents := range files {
+		err := ioutil.WriteFile(name, []byte(contents), 0644)
+		if err != nil {
+			panic(err)
+		}
+		defer os.Remove(name)
+	}
+	defer os.Remove("glove.bin")
+	defer os.Remove("fasttext.bin")
+
+	if format, err := DetectWordVecsFormat("glove.txt"); err != nil || format != GloVeText {
+		log.Panicf("Detected %v, %v for GloVe file", format, err)
+	}
+
+	wv, err := OpenAnyWordVecs("glove.txt", "glove.bin")
+	if err != nil {
+		panic(err)
+	}
+	if wv.Length() != 3 || wv.Get("of")[0] != 0.01 || wv.Get("and")[2] != 9 {
+		log.Panicf("Read wrong vectors from GloVe file: %v", wv.Get("of"))
+	}
+
+	if format, err := DetectWordVecsFormat("glove.bin"); err != nil || format != Word2VecBinary {
+		log.Panicf("Detected %v, %v for converted file", format, err)
+	}
+
+	wv, err = OpenAnyWordVecs("fasttext.vec", "fasttext.bin")
+	if err != nil {
+		panic(err)
+	}
+	if wv.Length() != 3 || wv.Get("and")[1] != 6 {
+		log.Panicf("Read wrong vectors from .vec file: %v", wv.Get("and"))
+	}
+
+	err = ConvertWordVecs("bad.vec", "bad.bin")
+	if err == nil || !strings.Contains(err.Error(), "line 3") {
+		log.Panicf("Expected an error on line 3, got %v", err)
+	}
+
+	// words of several tokens are read from text, but cannot be converted
+	if format, err := DetectWordVecsFormat("phrases.vec"); err != nil || format != Word2VecText {
+		log.Panicf("Detected %v, %v for file of phrases", format, err)
+	}
+
+	var phrase string
+	f, err := os.Open("phrases.vec")
+	if err != nil {
+		panic(err)
+	}
+	err = ReadTextWordVecs(f, Word2VecText, func(word string, vec []float32) error {
+		if vec[0] == 5 {
+			phrase = word
+		}
+		return nil
+	})
+	f.Close()
+	if err != nil || phrase != "los angeles" {
+		log.Panicf("Read phrase %q, %v", phrase, err)
+	}
+
+	err = ConvertWordVecs("phrases.vec", "phrases.bin")
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		log.Panicf("Expected an error on line 2, got %v", err)
+	}
+}
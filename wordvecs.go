@@ -7,8 +7,10 @@ import (
 	"log"
 	"math"
 	_ "net/http/pprof"
+	"runtime"
 	"strings"
-	"sync"
+	"sync/atomic"
+	"unsafe"
 
 	"golang.org/x/exp/mmap"
 )
@@ -28,11 +30,55 @@ type WordVecs struct {
 	n     int
 	file  *mmap.ReaderAt
 	index map[string]int64
-	cache map[string][]float32
+	cache *vectorCache
 	all   []string
-	mutex sync.Mutex
+
+	// the whole file mapped for zero copy lookups, or nil
+	data  []byte
+	unmap func() error
+
+	// set once a slice of data has been returned
+	aliased int32
+
+	subwords *Subwords
+	encoder  *QueryEncoder
+}
+
+// WordVecsOptions configures how the vectors are read from the file.
+type WordVecsOptions struct {
+	// The budget in bytes of the cache of decoded vectors. Defaults to 64MB.
+	// Negative to disable the cache.
+	CacheBytes int64
+
+	// Return slices aliasing the mapped file instead of decoding vectors. This
+	// needs a little endian platform that can map files, and falls back to
+	// decoding elsewhere. The slices must not be modified, and are only valid
+	// until the mapping is released by Unmap; Close keeps it once any have
+	// been returned.
+	ZeroCopy bool
 }
 
+func getWordVecsOptions(in *WordVecsOptions) *WordVecsOptions {
+	var out WordVecsOptions
+	if in != nil {
+		out = *in
+	}
+
+	if out.CacheBytes == 0 {
+		out.CacheBytes = 64 << 20
+	}
+	return &out
+}
+
+var littleEndian = func() bool {
+	x := uint16(1)
+	return *(*byte)(unsafe.Pointer(&x)) == 1
+}()
+
+// whether vectors at offsets that are not a multiple of 4 can be aliased
+var unalignedFloats = runtime.GOARCH == "amd64" || runtime.GOARCH == "386" ||
+	runtime.GOARCH == "arm64" || runtime.GOARCH == "ppc64le"
+
 func readUntil(file *mmap.ReaderAt, at int, ch byte) (string, int) {
 	var result strings.Builder
 	for at < file.Len() {
@@ -48,6 +94,11 @@ func readUntil(file *mmap.ReaderAt, at int, ch byte) (string, int) {
 
 // OpenWordVecs ...
 func OpenWordVecs(filename string) *WordVecs {
+	return OpenWordVecsWithOptions(filename, nil)
+}
+
+func OpenWordVecsWithOptions(filename string, options *WordVecsOptions) *WordVecs {
+	opt := getWordVecsOptions(options)
 	file, err := mmap.Open(filename)
 	if err != nil {
 		log.Panic(err)
@@ -56,7 +107,17 @@ func OpenWordVecs(filename string) *WordVecs {
 	wv := &WordVecs{
 		file:  file,
 		index: make(map[string]int64),
-		cache: make(map[string][]float32),
+	}
+
+	if opt.CacheBytes > 0 {
+		wv.cache = newVectorCache(opt.CacheBytes)
+	}
+
+	if opt.ZeroCopy && littleEndian {
+		wv.data, wv.unmap, err = mapFile(filename)
+		if err != nil {
+			log.Printf("Decoding vectors instead of aliasing them: %v", err)
+		}
 	}
 
 	header, at := readUntil(file, 0, 0x0a)
@@ -77,16 +138,72 @@ func OpenWordVecs(filename string) *WordVecs {
 	return wv
 }
 
-// Get ...
-func (wv *WordVecs) Get(word string) []float32 {
-	wv.mutex.Lock()
-	defer wv.mutex.Unlock()
-	if item := wv.cache[word]; item != nil {
-		return item
+// Close closes the file. In zero copy mode, the mapping is kept if slices of
+// it have been returned, since they may still be in use. It only takes address
+// space, as the pages are backed by the file; Unmap releases it.
+func (wv *WordVecs) Close() error {
+	if atomic.LoadInt32(&wv.aliased) == 0 {
+		wv.Unmap()
+	}
+	return wv.file.Close()
+}
+
+// Unmap releases the mapping used in zero copy mode. Slices returned by Get and
+// Lookup must not be used afterwards, nor the WordVecs from other goroutines
+// while it runs.
+func (wv *WordVecs) Unmap() error {
+	if wv.unmap == nil {
+		return nil
+	}
+
+	err := wv.unmap()
+	wv.data, wv.unmap = nil, nil
+	return err
+}
+
+// SetSubwords makes Get synthesize vectors for unknown words from the
+// character n-grams of a fastText model, which should have been trained
+// together with the vectors.
+func (wv *WordVecs) SetSubwords(s *Subwords) {
+	if s != nil && s.Dims() != wv.d {
+		log.Panicf("Subword vectors have %d dimensions, expected %d", s.Dims(), wv.d)
 	}
+	wv.subwords = s
+}
+
+// Get returns the vector of a word, or nil if it is unknown. As with Lookup,
+// in zero copy mode the vector may alias the mapped file.
+func (wv *WordVecs) Get(word string) []float32 {
+	vec, _ := wv.Lookup(word)
+	return vec
+}
+
+// Lookup returns the vector of a word, and whether it was synthesized from
+// subwords because the word is unknown. In zero copy mode, the vector may alias
+// the mapped file, so it must not be modified or used after Unmap.
+func (wv *WordVecs) Lookup(word string) ([]float32, bool) {
 	pos, ok := wv.index[word]
+	if ok && wv.data != nil && (pos%4 == 0 || unalignedFloats) {
+		atomic.StoreInt32(&wv.aliased, 1)
+		return (*[1 << 28]float32)(unsafe.Pointer(&wv.data[pos]))[:wv.d:wv.d], false
+	}
+
+	if wv.cache != nil {
+		if vec, synthesized, found := wv.cache.get(word); found {
+			return vec, synthesized
+		}
+	}
+
 	if !ok {
-		return nil
+		if wv.subwords != nil {
+			if vec := wv.subwords.Vector(word); vec != nil {
+				if wv.cache != nil {
+					wv.cache.put(word, vec, true)
+				}
+				return vec, true
+			}
+		}
+		return nil, false
 	}
 
 	result := make([]float32, wv.d)
@@ -101,8 +218,10 @@ func (wv *WordVecs) Get(word string) []float32 {
 	if err != nil {
 		log.Panic(err)
 	}
-	wv.cache[word] = result
-	return result
+	if wv.cache != nil {
+		wv.cache.put(word, result, false)
+	}
+	return result, false
 }
 
 // Distance ...
@@ -139,24 +258,23 @@ func (wv *WordVecs) At(i int) Point {
 	}
 }
 
-func (wv *WordVecs) PointFromQuery(text string) *WordVector {
-	total := make([]float32, wv.d)
-	for _, word := range RemoveDuplicateStrings(Tokenize(text)) {
-		vec := wv.Get(word)
-		for i := range vec {
-			total[i] += vec[i]
-		}
-	}
+// SetQueryEncoder sets the encoder used by PointFromQuery.
+func (wv *WordVecs) SetQueryEncoder(e *QueryEncoder) {
+	wv.encoder = e
+}
 
-	return &WordVector{
-		Word:   text,
-		Vector: total,
+// PointFromQuery returns the vector of a text. Without an encoder, this is
+// the sum of the vectors of its distinct words.
+func (wv *WordVecs) PointFromQuery(text string) *WordVector {
+	if wv.encoder != nil {
+		return wv.encoder.Encode(text)
 	}
+	return NewQueryEncoder(wv, nil).Encode(text)
 }
 
+// Tokenize splits text into lowercase words with a UnicodeTokenizer.
 func Tokenize(text string) []string {
-	text = strings.ToLower(text)
-	return strings.Split(text, " ")
+	return (&UnicodeTokenizer{}).Tokenize(text)
 }
 
 func RemoveDuplicateStrings(input []string) []string {